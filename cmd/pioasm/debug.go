@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+	"github.com/bozydar/pioasm-compiler/emulator"
+)
+
+const debugHelp = `Commands:
+  step [n]                 run n cycles, 1 if omitted
+  continue [n]             run until a breakpoint, a watched value changes or n cycles, 100000 if omitted
+  break [label|pc]         set a breakpoint, list them if omitted
+  delete <label|pc>        delete a breakpoint
+  watch <name>             show and stop on changes of pc, x, y, isr, osr, tx or rx
+  unwatch <name>           stop watching
+  pin <n> <0|1>            drive the input pin n
+  pindir <n> <in|out>      set the direction of the pin n
+  put <word>               write the word to the TX FIFO
+  get                      read a word from the RX FIFO
+  regs                     show the state machine
+  list                     show the source around pc
+  quit                     exit`
+
+// debugger runs a program on the state machine 0 of an emulated PIO block.
+type debugger struct {
+	out         io.Writer
+	program     *compiler.AstProgram
+	pio         *emulator.PIO
+	sm          *emulator.StateMachine
+	breakpoints map[int]bool
+	watches     []string
+	// path is the debugged file, sources are its lines and the ones of the included files read so far
+	path    string
+	sources map[string][]string
+}
+
+func debugCommand(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	name := flags.String("program", "", "program to debug, the first of the file if empty")
+	var config emulator.Config
+	flags.IntVar(&config.OutBase, "out-base", 0, "first pin of out and mov pins")
+	flags.IntVar(&config.SetBase, "set-base", 0, "first pin of set")
+	flags.IntVar(&config.SideSetBase, "side-set-base", 0, "first pin of the side-set")
+	flags.IntVar(&config.InBase, "in-base", 0, "first pin of in and wait pin")
+	flags.IntVar(&config.JmpPin, "jmp-pin", 0, "pin of jmp pin")
+	outCount := flags.Int("out-count", -1, "number of pins of out and mov pins, the one of .out if negative")
+	setCount := flags.Int("set-count", -1, "number of pins of set, the one of .set if negative")
+	inCount := flags.Int("in-count", -1, "number of pins of in, the one of .in if negative")
+	options := compilerFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: pioasm debug [flags] <file.pio>")
+	}
+
+	file, source, err := compileFile(flags.Arg(0), options)
+	if err != nil {
+		return err
+	}
+	var program *compiler.AstProgram
+	if *name == "" && len(file.Programs()) > 0 {
+		program = file.Programs()[0]
+	} else {
+		program = file.Program(*name)
+	}
+	if program == nil {
+		return fmt.Errorf("program `%s` not found", *name)
+	}
+
+	pio := emulator.New(program.Version())
+	if err := pio.LoadProgram(program, 0); err != nil {
+		return err
+	}
+	defaults := emulator.NewConfig(program, 0)
+	config.SmConfig = defaults.SmConfig
+	if err := overrideCount(&config.OutCount, *outCount, 32, "out"); err != nil {
+		return err
+	}
+	if err := overrideCount(&config.SetCount, *setCount, 5, "set"); err != nil {
+		return err
+	}
+	if err := overrideCount(&config.InCount, *inCount, 32, "in"); err != nil {
+		return err
+	}
+	sm := pio.StateMachine(0)
+	sm.Init(0, config)
+	sm.SetEnabled(true)
+
+	d := &debugger{
+		out:         out,
+		path:        flags.Arg(0),
+		sources:     map[string][]string{flags.Arg(0): splitLines(source)},
+		program:     program,
+		pio:         pio,
+		sm:          sm,
+		breakpoints: make(map[int]bool),
+	}
+
+	return d.run(in)
+}
+
+// run reads the commands until `quit` or the end of the input.
+func (d *debugger) run(in io.Reader) error {
+	fmt.Fprintf(d.out, "Debugging `%s`, `help` lists the commands\n", d.program.Name())
+	d.showPosition()
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(d.out, "(pio) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return nil
+		}
+		if err := d.execute(fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(d.out, "Error: %s\n", err)
+		}
+	}
+}
+
+func (d *debugger) execute(command string, args []string) error {
+	switch command {
+	case "help", "h":
+		fmt.Fprintln(d.out, debugHelp)
+	case "step", "s":
+		cycles, err := optionalCount(args, 1)
+		if err != nil {
+			return err
+		}
+		return d.runCycles(cycles, false)
+	case "continue", "c":
+		cycles, err := optionalCount(args, 100000)
+		if err != nil {
+			return err
+		}
+		return d.runCycles(cycles, true)
+	case "break", "b":
+		if len(args) == 0 {
+			d.listBreakpoints()
+			return nil
+		}
+		pc, err := d.location(args)
+		if err != nil {
+			return err
+		}
+		d.breakpoints[pc] = true
+		fmt.Fprintf(d.out, "Breakpoint at %d\n", pc)
+	case "delete", "d":
+		pc, err := d.location(args)
+		if err != nil {
+			return err
+		}
+		if !d.breakpoints[pc] {
+			return fmt.Errorf("no breakpoint at %d", pc)
+		}
+		delete(d.breakpoints, pc)
+	case "watch", "w":
+		if len(args) != 1 {
+			return errors.New("expected a name")
+		}
+		if _, ok := d.value(args[0]); !ok {
+			return fmt.Errorf("unknown value `%s`", args[0])
+		}
+		d.watches = append(d.watches, args[0])
+		d.showWatches()
+	case "unwatch":
+		for i, watch := range d.watches {
+			if len(args) == 1 && watch == args[0] {
+				d.watches = append(d.watches[:i], d.watches[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("expected a watched name")
+	case "pin":
+		if len(args) != 2 || (args[1] != "0" && args[1] != "1") {
+			return errors.New("expected a pin and 0 or 1")
+		}
+		pin, err := parsePin(args[0])
+		if err != nil {
+			return err
+		}
+		d.pio.GPIO().SetInput(pin, args[1] == "1")
+	case "pindir":
+		if len(args) != 2 || (args[1] != "in" && args[1] != "out") {
+			return errors.New("expected a pin and `in` or `out`")
+		}
+		pin, err := parsePin(args[0])
+		if err != nil {
+			return err
+		}
+		d.sm.SetConsecutivePindirs(pin, 1, args[1] == "out")
+	case "put":
+		if len(args) != 1 {
+			return errors.New("expected a word")
+		}
+		word, err := strconv.ParseUint(args[0], 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid word `%s`", args[0])
+		}
+		if !d.sm.Put(uint32(word)) {
+			return errors.New("TX FIFO full")
+		}
+	case "get":
+		word, ok := d.sm.Get()
+		if !ok {
+			return errors.New("RX FIFO empty")
+		}
+		fmt.Fprintf(d.out, "0x%08x\n", word)
+	case "regs", "r":
+		d.showRegisters()
+	case "list", "l":
+		d.showSource()
+	default:
+		return fmt.Errorf("unknown command `%s`, `help` lists the commands", command)
+	}
+
+	return nil
+}
+
+// runCycles steps the PIO block. Continuing stops at breakpoints and changes of the watched values.
+func (d *debugger) runCycles(cycles int, continuing bool) error {
+	reason := ""
+	for i := 0; i < cycles && reason == ""; i++ {
+		delay := d.sm.Delay()
+		before := d.watchedValues()
+		if err := d.pio.Step(); err != nil {
+			d.showPosition()
+			return err
+		}
+		if !continuing {
+			continue
+		}
+		// A breakpoint stops before the instruction executes, once the previous one has completed and its delay
+		// is over. A stalled instruction doesn't stop again.
+		arrived := d.sm.Completed() || delay > 0
+		if arrived && d.sm.Delay() == 0 && d.breakpoints[d.sm.PC()] {
+			reason = fmt.Sprintf("Breakpoint at %d", d.sm.PC())
+		}
+		for j, value := range d.watchedValues() {
+			if value != before[j] {
+				reason = fmt.Sprintf("`%s` changed", d.watches[j])
+			}
+		}
+	}
+	if reason != "" {
+		fmt.Fprintln(d.out, reason)
+	}
+	d.showPosition()
+	d.showWatches()
+
+	return nil
+}
+
+// location resolves a label or a pc.
+func (d *debugger) location(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected a label or a pc")
+	}
+	if pc, ok := d.program.Label(args[0]); ok {
+		return pc, nil
+	}
+	pc, err := strconv.Atoi(args[0])
+	if err != nil || pc < 0 || pc >= len(d.program.Instructions()) {
+		return 0, fmt.Errorf("no label or pc `%s`", args[0])
+	}
+
+	return pc, nil
+}
+
+// value returns the value of a watchable name.
+func (d *debugger) value(name string) (uint32, bool) {
+	switch name {
+	case "pc":
+		return uint32(d.sm.PC()), true
+	case "x":
+		return d.sm.X(), true
+	case "y":
+		return d.sm.Y(), true
+	case "isr":
+		return d.sm.ISR(), true
+	case "osr":
+		return d.sm.OSR(), true
+	case "tx":
+		return uint32(d.sm.TxLevel()), true
+	case "rx":
+		return uint32(d.sm.RxLevel()), true
+	}
+
+	return 0, false
+}
+
+func (d *debugger) watchedValues() []uint32 {
+	values := make([]uint32, len(d.watches))
+	for i, watch := range d.watches {
+		values[i], _ = d.value(watch)
+	}
+
+	return values
+}
+
+func (d *debugger) showWatches() {
+	for _, watch := range d.watches {
+		value, _ := d.value(watch)
+		fmt.Fprintf(d.out, "  %s = 0x%x\n", watch, value)
+	}
+}
+
+func (d *debugger) showPosition() {
+	pc := d.sm.PC()
+	state := ""
+	if d.sm.Stalled() {
+		state = " (stalled)"
+	} else if delay := d.sm.Delay(); delay > 0 {
+		state = fmt.Sprintf(" (delay %d)", delay)
+	}
+	fmt.Fprintf(d.out, "cycle %d, pc %d%s: %s\n", d.pio.Cycles(), pc, state, d.sourceLine(pc))
+}
+
+func (d *debugger) showRegisters() {
+	gpio := d.pio.GPIO()
+	fmt.Fprintf(d.out, "pc   %d\nx    0x%08x\ny    0x%08x\nisr  0x%08x (%d bits in)\nosr  0x%08x (%d bits out)\n",
+		d.sm.PC(), d.sm.X(), d.sm.Y(), d.sm.ISR(), d.sm.ISRCount(), d.sm.OSR(), d.sm.OSRCount())
+	fmt.Fprintf(d.out, "tx   %d\nrx   %d\npins 0x%08x (outputs 0x%08x, enabled 0x%08x)\nirq  0x%02x\n",
+		d.sm.TxLevel(), d.sm.RxLevel(), gpio.Levels(), gpio.Outputs(), gpio.OutputEnables(), d.pio.IRQ())
+}
+
+// showSource prints the source lines around the instruction at pc.
+func (d *debugger) showSource() {
+	_, line, lines := d.source(d.sm.PC())
+	for i := line - 3; i <= line+3; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := "  "
+		if i == line {
+			marker = "=>"
+		}
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, i, lines[i-1])
+	}
+}
+
+func (d *debugger) listBreakpoints() {
+	pcs := make([]int, 0, len(d.breakpoints))
+	for pc := range d.breakpoints {
+		pcs = append(pcs, pc)
+	}
+	sort.Ints(pcs)
+	for _, pc := range pcs {
+		fmt.Fprintf(d.out, "Breakpoint at %d: %s\n", pc, d.sourceLine(pc))
+	}
+}
+
+// sourceLine returns the line of the source of the instruction at pc, or its disassembly.
+// The lines of the included files are prefixed with the file.
+func (d *debugger) sourceLine(pc int) string {
+	file, line, lines := d.source(pc)
+	if lines == nil {
+		return d.program.Disassemble(d.pio.Instruction(pc))
+	}
+	if file != d.path {
+		return fmt.Sprintf("%s line %d: %s", file, line, strings.TrimSpace(lines[line-1]))
+	}
+
+	return fmt.Sprintf("line %d: %s", line, strings.TrimSpace(lines[line-1]))
+}
+
+// source returns the file and the line of the instruction at pc with the lines of the file,
+// which are nil if the line is unknown. The included files are read the first time they are needed.
+func (d *debugger) source(pc int) (string, int, []string) {
+	file, line := d.program.SourceFile(pc), d.program.SourceLine(pc)
+	lines, read := d.sources[file]
+	if !read {
+		if content, err := os.ReadFile(file); err == nil {
+			lines = splitLines(content)
+		}
+		d.sources[file] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return file, 0, nil
+	}
+
+	return file, line, lines
+}
+
+func splitLines(source []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(source), "\n"), "\n")
+}
+
+// overrideCount sets the pin count unless the count given is negative.
+func overrideCount(count *int, value int, max int, name string) error {
+	if value < 0 {
+		return nil
+	}
+	if value > max {
+		return fmt.Errorf("%s count must be in 0..%d", name, max)
+	}
+	*count = value
+
+	return nil
+}
+
+func optionalCount(args []string, defaultCount int) (int, error) {
+	if len(args) == 0 {
+		return defaultCount, nil
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid count `%s`", args[0])
+	}
+
+	return count, nil
+}
+
+func parsePin(arg string) (int, error) {
+	pin, err := strconv.Atoi(arg)
+	if err != nil || pin < 0 || pin > 31 {
+		return 0, fmt.Errorf("invalid pin `%s`", arg)
+	}
+
+	return pin, nil
+}
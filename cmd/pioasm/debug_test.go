@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const uartTx = `.program uart_tx
+.side_set 1 opt
+	pull       side 1 [7]
+	set x, 7   side 0 [7]
+bitloop:
+	out pins, 1
+	jmp x-- bitloop [6]
+`
+
+// debugScript runs the debugger on the source with the commands and returns its output.
+func debugScript(t *testing.T, source string, commands string, args ...string) string {
+	path := filepath.Join(t.TempDir(), "test.pio")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+
+	if err := debugCommand(append(args, path), strings.NewReader(commands), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String()
+}
+
+func Test_Debug(t *testing.T) {
+	t.Run("Stops at breakpoints on labels.", func(t *testing.T) {
+		out := debugScript(t, uartTx, "break bitloop\nput 0x41\ncontinue\ncontinue\n")
+
+		if !strings.Contains(out, "(pio) Breakpoint at 2\n(pio) (pio) Breakpoint at 2\ncycle 16, pc 2: line 6: out pins, 1\n"+
+			"(pio) Breakpoint at 2\ncycle 24, pc 2: line 6: out pins, 1\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Stops at breakpoints on one instruction loops.", func(t *testing.T) {
+		out := debugScript(t, ".program spin\nloop:\n\tjmp loop\n", "break loop\ncontinue\ncontinue\n")
+
+		if !strings.Contains(out, "(pio) Breakpoint at 0\n(pio) Breakpoint at 0\ncycle 1, pc 0: line 3: jmp loop\n"+
+			"(pio) Breakpoint at 0\ncycle 2, pc 0: line 3: jmp loop\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Stops on changes of watched values.", func(t *testing.T) {
+		out := debugScript(t, uartTx, "watch tx\nwatch x\nput 1\ncontinue\ncontinue\n")
+
+		if !strings.Contains(out, "(pio)   tx = 0x0\n  x = 0x0\n(pio) (pio) `tx` changed\n"+
+			"cycle 1, pc 1 (delay 7): line 4: set x, 7   side 0 [7]\n  tx = 0x0\n  x = 0x0\n"+
+			"(pio) `x` changed\ncycle 9, pc 2 (delay 7): line 6: out pins, 1\n  tx = 0x0\n  x = 0x7\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Shows the source and the registers.", func(t *testing.T) {
+		out := debugScript(t, uartTx, "step 9\nlist\nregs\n")
+
+		if !strings.Contains(out, "cycle 9, pc 0 (stalled): line 3: pull       side 1 [7]\n"+
+			"(pio)       1  .program uart_tx\n      2  .side_set 1 opt\n=>    3  \tpull       side 1 [7]\n"+
+			"      4  \tset x, 7   side 0 [7]\n      5  bitloop:\n      6  \tout pins, 1\n(pio) pc   0\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Drives pins and uses the pin configuration.", func(t *testing.T) {
+		out := debugScript(t, `.program echo
+	wait 1 pin 0
+	in pins, 1
+	push
+`, "pin 5 1\ncontinue 3\nget\n", "-in-base", "5")
+
+		if !strings.Contains(out, "(pio) 0x80000000\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Uses the pin counts of the flags.", func(t *testing.T) {
+		out := debugScript(t, `.program blink
+	set pindirs, 3
+	set pins, 3
+`, "step 2\nregs\n", "-set-count", "2", "-set-base", "4")
+
+		if !strings.Contains(out, "pins 0x00000030 (outputs 0x00000030, enabled 0x00000030)\n") {
+			t.Log(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Shows the source of the included files.", func(t *testing.T) {
+		dir := t.TempDir()
+		included := filepath.Join(dir, "blink.pio")
+		if err := os.WriteFile(included, []byte(".program blink\n\tset pins, 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "main.pio")
+		if err := os.WriteFile(path, []byte(".include \"blink.pio\"\n\tset pins, 0\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+
+		if err := debugCommand([]string{path}, strings.NewReader("list\nstep\n"), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "cycle 0, pc 0: "+included+" line 2: set pins, 1\n"+
+			"(pio)       1  .program blink\n=>    2  \tset pins, 1\n(pio) cycle 1, pc 1: line 2: set pins, 0\n") {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Reports invalid commands.", func(t *testing.T) {
+		out := debugScript(t, uartTx, "foo\nbreak nowhere\npin 32 1\nget\nstep 0\n")
+
+		for _, message := range []string{
+			"Error: unknown command `foo`, `help` lists the commands\n",
+			"Error: no label or pc `nowhere`\n",
+			"Error: invalid pin `32`\n",
+			"Error: RX FIFO empty\n",
+			"Error: invalid count `0`\n",
+		} {
+			if !strings.Contains(out, message) {
+				t.Log(out)
+				t.Errorf("Missing `%s`", message)
+			}
+		}
+	})
+
+	t.Run("Error if a pin count is out of range.", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.pio")
+		_ = os.WriteFile(path, []byte(uartTx), 0o644)
+
+		err := debugCommand([]string{"-set-count", "6", path}, strings.NewReader(""), &bytes.Buffer{})
+		if err == nil || err.Error() != "set count must be in 0..5" {
+			t.Errorf("%v", err)
+		}
+	})
+
+	t.Run("Error if the program doesn't compile.", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.pio")
+		_ = os.WriteFile(path, []byte(".program test\nbullshit\n"), 0o644)
+
+		err := debugCommand([]string{path}, strings.NewReader(""), &bytes.Buffer{})
+		if err == nil || err.Error() != "Unexpected item: "+path+":2:1" {
+			t.Errorf("%v", err)
+		}
+	})
+}
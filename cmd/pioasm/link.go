@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+func linkCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("link", flag.ContinueOnError)
+	names := flags.String("programs", "", "comma separated programs to link, all of the files if empty")
+	options := compilerFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: pioasm link [flags] <file.pio>...")
+	}
+
+	var programs []*compiler.AstProgram
+	for _, path := range flags.Args() {
+		file, _, err := compileFile(path, options)
+		if err != nil {
+			return err
+		}
+		programs = append(programs, file.Programs()...)
+	}
+	if *names != "" {
+		selected := make([]*compiler.AstProgram, 0)
+		for _, name := range strings.Split(*names, ",") {
+			program := findProgram(programs, strings.TrimSpace(name))
+			if program == nil {
+				return fmt.Errorf("program `%s` not found", name)
+			}
+			selected = append(selected, program)
+		}
+		programs = selected
+	}
+
+	image, err := compiler.Link(programs...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(out, image.Map())
+	fmt.Fprintln(out)
+	for _, placement := range image.Placements {
+		for i := range placement.Program.Instructions() {
+			offset := placement.Offset + i
+			word := image.Words[offset]
+			fmt.Fprintf(out, "%2d: 0x%04x  %s\n", offset, word, placement.Program.Disassemble(word))
+		}
+	}
+
+	return nil
+}
+
+// findProgram returns the program of the name or nil.
+func findProgram(programs []*compiler.AstProgram, name string) *compiler.AstProgram {
+	for _, program := range programs {
+		if program.Name() == name {
+			return program
+		}
+	}
+
+	return nil
+}
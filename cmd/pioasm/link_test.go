@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Link(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, source string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+	blink := write("blink.pio", `.program blink
+loop:
+	set pins, 1
+	jmp loop
+`)
+	uart := write("uart.pio", `.program uart
+.origin 0
+	nop
+.program unused
+	nop
+`)
+
+	t.Run("Links the programs of the files.", func(t *testing.T) {
+		var out bytes.Buffer
+
+		if err := linkCommand([]string{"-programs", "blink,uart", blink, uart}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != ` 0..0   uart (.origin)
+ 1..29  free
+30..31  blink
+
+30: 0xe001  set    pins, 1
+31: 0x001e  jmp    30
+ 0: 0xa042  nop
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Links the programs of the PIO version.", func(t *testing.T) {
+		fifo := write("fifo.pio", `.program fifo
+	mov rxfifo[y], isr
+	mov osr, rxfifo[2]
+`)
+		var out bytes.Buffer
+
+		if err := linkCommand([]string{"-pio-version", "1", fifo}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != ` 0..29  free
+30..31  fifo
+
+30: 0x8010  mov    rxfifo[y], isr
+31: 0x809a  mov    osr, rxfifo[2]
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Links the programs with forward references to defines.", func(t *testing.T) {
+		forward := write("forward.pio", `.define B A + 1
+.define A 1
+.program forward
+	set x, B
+`)
+		var out bytes.Buffer
+
+		if err := linkCommand([]string{"-forward-refs", forward}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != ` 0..30  free
+31..31  forward
+
+31: 0xe022  set    x, 2
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+		if err := linkCommand([]string{forward}, &bytes.Buffer{}); err == nil {
+			t.Errorf("Forward reference is accepted without -forward-refs")
+		}
+	})
+
+	t.Run("Links the programs without evaluating the unused defines.", func(t *testing.T) {
+		unused := write("unused.pio", `.define UNUSED 1 / 0
+.program unused
+	set x, 1
+`)
+		var out bytes.Buffer
+
+		if err := linkCommand([]string{"-eval-define=false", unused}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != ` 0..30  free
+31..31  unused
+
+31: 0xe021  set    x, 1
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+		if err := linkCommand([]string{unused}, &bytes.Buffer{}); err == nil {
+			t.Errorf("Unused define is not evaluated by default")
+		}
+	})
+
+	t.Run("Links the programs of the files included from the directories.", func(t *testing.T) {
+		for _, lib := range []string{"lib", "vendor"} {
+			if err := os.Mkdir(filepath.Join(dir, lib), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		write(filepath.Join("vendor", "idle.pio"), ".program idle\n\tnop\n")
+		including := write("including.pio", `.include "idle.pio"`)
+		var out bytes.Buffer
+
+		if err := linkCommand([]string{"-I", filepath.Join(dir, "lib"), "-I", filepath.Join(dir, "vendor"), including}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != ` 0..30  free
+31..31  idle
+
+31: 0xa042  nop
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+		if err := linkCommand([]string{including}, &bytes.Buffer{}); err == nil {
+			t.Errorf("Included file is found without -I")
+		}
+	})
+
+	t.Run("Error if the PIO version is unknown.", func(t *testing.T) {
+		err := linkCommand([]string{"-pio-version", "2", blink}, &bytes.Buffer{})
+
+		if err == nil || err.Error() != "PIO version must be 0 or 1" {
+			t.Errorf("%v", err)
+		}
+	})
+
+	t.Run("Error if the program is missing.", func(t *testing.T) {
+		err := linkCommand([]string{"-programs", "missing", blink}, &bytes.Buffer{})
+
+		if err == nil || err.Error() != "program `missing` not found" {
+			t.Errorf("%v", err)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// Command pioasm works with PIO programs.
+//
+//	pioasm debug [flags] <file.pio>
+//	pioasm link [flags] <file.pio>...
+//	pioasm timing [flags] <file.pio>
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "debug":
+		err = debugCommand(os.Args[2:], os.Stdin, os.Stdout)
+	case "link":
+		err = linkCommand(os.Args[2:], os.Stdout)
+	case "timing":
+		err = timingCommand(os.Args[2:], os.Stdout)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: pioasm <command> [arguments]
+
+Commands:
+  debug    run a program in the emulator with an interactive debugger
+  link     pack the programs into the instruction memory of a PIO block
+  timing   count the cycles between the labels of the programs`)
+	os.Exit(2)
+}
+
+// stderr receives the warnings of the compiler.
+var stderr io.Writer = os.Stderr
+
+// compilerFlags adds the flags of the compiler options to the flag set.
+func compilerFlags(flags *flag.FlagSet) *compiler.Options {
+	options := &compiler.Options{}
+	flags.BoolVar(&options.WarnWraparound, "warn-wraparound", false, "warn about expressions which don't fit 32 bits")
+	flags.IntVar(&options.PioVersion, "pio-version", 0, "PIO version of the programs without .pio_version: 0 for RP2040, 1 for RP2350")
+	flags.BoolVar(&options.ForwardRefs, "forward-refs", false, "allow defines to reference the ones declared further in the source")
+	flags.Var((*stringList)(&options.IncludePaths), "I", "`directory` searched for the included files, can be repeated")
+	flags.Var(negatedBool{&options.LazyDefines}, "eval-define", "evaluate every define, if false only the public ones and the ones in use (default true)")
+
+	return options
+}
+
+// stringList is a flag which appends its values, e.g. `-I lib -I vendor`.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+
+	return nil
+}
+
+// negatedBool is a boolean flag which sets the negation of its value, e.g. `-eval-define=false` sets LazyDefines.
+type negatedBool struct {
+	value *bool
+}
+
+func (b negatedBool) String() string {
+	if b.value == nil {
+		return "true"
+	}
+
+	return strconv.FormatBool(!*b.value)
+}
+
+func (b negatedBool) Set(s string) error {
+	value, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.value = !value
+
+	return nil
+}
+
+func (b negatedBool) IsBoolFlag() bool {
+	return true
+}
+
+// compileFile compiles the file, prints the warnings and returns the source too.
+func compileFile(path string, options *compiler.Options) (*compiler.AstFile, []byte, error) {
+	if options.PioVersion < 0 || options.PioVersion > 1 {
+		return nil, nil, errors.New("PIO version must be 0 or 1")
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, e := compiler.CompileFile(path, options)
+	if e != nil {
+		return nil, nil, errors.New(e.ToString())
+	}
+	for _, warning := range file.Warnings() {
+		fmt.Fprintf(stderr, "Warning: %s\n", warning.ToString())
+	}
+
+	return file, source, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+func timingCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("timing", flag.ContinueOnError)
+	name := flags.String("program", "", "program to analyze, all of the file if empty")
+	clock := flags.Float64("clock", 125e6, "system clock in Hz")
+	divider := flags.Float64("div", 0, "clock divider, the one of `.clock_div` if zero")
+	options := compilerFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: pioasm timing [flags] <file.pio>")
+	}
+
+	file, _, err := compileFile(flags.Arg(0), options)
+	if err != nil {
+		return err
+	}
+	programs := file.Programs()
+	if *name != "" {
+		program := file.Program(*name)
+		if program == nil {
+			return fmt.Errorf("program `%s` not found", *name)
+		}
+		programs = []*compiler.AstProgram{program}
+	}
+
+	for _, program := range programs {
+		programDivider := *divider
+		if programDivider == 0 {
+			programDivider = program.DefaultConfig().ClockDiv
+		}
+		fmt.Fprint(out, program.Timing().Report(*clock, programDivider))
+	}
+
+	return nil
+}
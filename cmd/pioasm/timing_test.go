@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Timing(t *testing.T) {
+	source := `.program blink
+.clock_div 4
+loop:
+	set pins, 1 [1]
+	set pins, 0
+	jmp loop
+.program idle
+	nop
+`
+	path := filepath.Join(t.TempDir(), "test.pio")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Reports the programs with their clock divider.", func(t *testing.T) {
+		var out bytes.Buffer
+
+		if err := timingCommand([]string{"-clock", "16e6", path}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != `blink at 16 MHz / 4
+  loop -> loop             4 cycles, 1 MHz
+  wrap                     4 cycles, 1 MHz
+idle at 16 MHz / 1
+  0 -> 0                   1 cycles, 16 MHz
+  wrap                     1 cycles, 16 MHz
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Reports the program with the divider.", func(t *testing.T) {
+		var out bytes.Buffer
+
+		if err := timingCommand([]string{"-program", "blink", "-div", "1", path}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != `blink at 125 MHz / 1
+  loop -> loop             4 cycles, 31.25 MHz
+  wrap                     4 cycles, 31.25 MHz
+` {
+			t.Log(out.String())
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Prints the warnings if requested.", func(t *testing.T) {
+		wrapping := filepath.Join(t.TempDir(), "wrap.pio")
+		if err := os.WriteFile(wrapping, []byte(".define public A 0x80000000 * 2\n"+source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var warnings bytes.Buffer
+		stderr = &warnings
+		defer func() { stderr = os.Stderr }()
+
+		if err := timingCommand([]string{"-warn-wraparound", "-program", "idle", wrapping}, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+		if warnings.String() != "Warning: Expression value wraps around 32 bits: "+wrapping+":1:29\n" {
+			t.Errorf("%q", warnings.String())
+		}
+	})
+
+	t.Run("Error if the program is missing.", func(t *testing.T) {
+		err := timingCommand([]string{"-program", "missing", path}, &bytes.Buffer{})
+
+		if err == nil || err.Error() != "program `missing` not found" {
+			t.Errorf("%v", err)
+		}
+	})
+}
@@ -3,7 +3,9 @@ package compiler
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
 )
 
 type Ast interface {
@@ -11,17 +13,35 @@ type Ast interface {
 }
 
 type Options struct {
-	evalDefine bool
+	// Evaluate the defines only when their value is needed and leave the rest unevaluated
+	LazyDefines bool
+	// Allow expressions to reference defines declared further in the source
+	ForwardRefs bool
+	// Report expressions which don't fit 32 bits and are silently truncated
+	WarnWraparound bool
+	// PIO version of the programs without `.pio_version`: 0 for RP2040, 1 for RP2350
+	PioVersion int
+	// Directories searched for the files of `.include` not found next to the including file
+	IncludePaths []string
 }
 
 type CompileError struct {
+	// file is empty for the source given to Compile
+	file    string
 	line    int
 	offset  int
 	message string
 }
 
 func (ce *CompileError) ToString() string {
-	return fmt.Sprintf("%s: %d:%d", ce.message, ce.line, ce.offset)
+	switch {
+	case ce.file == "":
+		return fmt.Sprintf("%s: %d:%d", ce.message, ce.line, ce.offset)
+	case ce.line == 0:
+		return fmt.Sprintf("%s: %s", ce.message, ce.file)
+	}
+
+	return fmt.Sprintf("%s: %s:%d:%d", ce.message, ce.file, ce.line, ce.offset)
 }
 
 type compiler struct {
@@ -34,46 +54,309 @@ type compiler struct {
 	currentProgram *AstProgram
 	globalSymbols  map[string]*AstDefine
 	programSymbols map[string]map[string]*AstDefine
+	warnings       []*CompileError
+	// Defines being evaluated, used to detect cycles
+	evaluating []*AstDefine
+	// PIO version of the program being assembled
+	pioVersion int
+	// Includes of the files being parsed, the last one read by lex
+	includes []*AstInclude
+	// Type of the last item returned by next, across the included files
+	lastItem itemType
 }
 
 type AstFile struct {
-	defines  []*AstDefine
-	programs []*AstProgram
+	pioVersion *AstPioVersion
+	includes   []*AstInclude
+	defines    []*AstDefine
+	codeBlocks []*AstCodeBlock
+	programs   []*AstProgram
+	warnings   []*CompileError
 }
 
+// ToSource renders the file without the items of the included files, which are left to their `.include`.
 func (a *AstFile) ToSource() string {
 	var b bytes.Buffer
 
+	if a.pioVersion != nil && !included(a.pioVersion.token) {
+		b.WriteString(a.pioVersion.ToSource() + "\n")
+	}
+	for _, include := range a.includes {
+		include.writeSource(&b)
+	}
+
 	for _, define := range a.defines {
-		b.WriteString(define.ToSource() + "\n")
+		if !included(define.token) {
+			b.WriteString(define.ToSource() + "\n")
+		}
+	}
+	for _, codeBlock := range a.codeBlocks {
+		if !included(codeBlock.token) {
+			b.WriteString(codeBlock.ToSource())
+		}
 	}
 
 	for _, program := range a.programs {
-		b.WriteString(program.ToSource())
+		if !included(program.token) {
+			b.WriteString(program.ToSource())
+		}
 	}
 
 	return b.String()
 }
 
 type AstProgram struct {
-	name      string
-	sideSet   uint8
-	defines   []*AstDefine
-	assembler []uint16
+	token               *lexItem
+	name                string
+	pioVersion          *AstPioVersion
+	sideSet             *AstSideSet
+	in                  *AstShiftConfig
+	out                 *AstShiftConfig
+	set                 *AstSetConfig
+	fifo                *AstFifoConfig
+	movStatus           *AstMovStatus
+	clockDiv            *AstClockDiv
+	origin              *AstOrigin
+	wrapTargetDirective *AstWrap
+	wrapDirective       *AstWrap
+	labels              []*AstLabel
+	langOpts            []*AstLangOpt
+	codeBlocks          []*AstCodeBlock
+	defines             []*AstDefine
+	instructions        []*AstInstruction
+	includes            []*AstInclude
+	assembler           []uint16
+	// Evaluated while assembling
+	version int
+	// Source file and line of each instruction, the file is empty for the source given to Compile
+	sourceFiles []string
+	sourceLines []int
+}
+
+// Programs returns the programs of the file in the order of the source.
+func (a *AstFile) Programs() []*AstProgram {
+	return a.programs
+}
+
+// Warnings returns the warnings raised while compiling the file.
+func (a *AstFile) Warnings() []*CompileError {
+	return a.warnings
+}
+
+// Program returns the program of the name or nil.
+func (a *AstFile) Program(name string) *AstProgram {
+	for _, program := range a.programs {
+		if program.name == name {
+			return program
+		}
+	}
+
+	return nil
 }
 
+func (a *AstProgram) Name() string {
+	return a.name
+}
+
+// Instructions returns the assembled instruction words.
+func (a *AstProgram) Instructions() []uint16 {
+	return append([]uint16(nil), a.assembler...)
+}
+
+// Version returns the PIO version targeted by the program.
+func (a *AstProgram) Version() int {
+	return a.version
+}
+
+// Origin returns the offset the program must be loaded at, -1 if it can be loaded anywhere.
+func (a *AstProgram) Origin() int {
+	if a.origin != nil {
+		return a.origin.value
+	}
+
+	return -1
+}
+
+// Label returns the offset of the label in the program.
+func (a *AstProgram) Label(name string) (int, bool) {
+	for _, label := range a.labels {
+		if label.name == name {
+			return label.index, true
+		}
+	}
+
+	return 0, false
+}
+
+// SourceLine returns the line of the source holding the instruction at the index, 0 if there is none.
+func (a *AstProgram) SourceLine(index int) int {
+	if index < 0 || index >= len(a.sourceLines) {
+		return 0
+	}
+
+	return a.sourceLines[index]
+}
+
+// SourceFile returns the file holding the instruction at the index, which differs from the compiled one
+// for the instructions of the included files. It is empty for the source given to Compile.
+func (a *AstProgram) SourceFile(index int) string {
+	if index < 0 || index >= len(a.sourceFiles) {
+		return ""
+	}
+
+	return a.sourceFiles[index]
+}
+
+// Disassemble renders the word as source with the side-set of the program.
+func (a *AstProgram) Disassemble(word uint16) string {
+	return disassemble(word, a.sideSet)
+}
+
+// wrapTarget is the index of the first instruction executed after the wrap.
+func (a *AstProgram) wrapTarget() int {
+	if a.wrapTargetDirective != nil {
+		return a.wrapTargetDirective.index
+	}
+
+	return 0
+}
+
+// wrap is the index of the last instruction before the wrap.
+func (a *AstProgram) wrap() int {
+	if a.wrapDirective != nil {
+		return a.wrapDirective.index
+	}
+
+	return len(a.assembler) - 1
+}
+
+// ToSource renders the program without the items of the included files, which are left to their `.include`.
+// The `.program` directive is left out too if it comes from an included file.
 func (a *AstProgram) ToSource() string {
 	var b bytes.Buffer
+	write := func(token *lexItem, source string) {
+		if !included(token) {
+			b.WriteString(source)
+		}
+	}
+	writeIncludes := func(index int) {
+		for _, include := range a.includes {
+			if include.index == index && !included(include.token) {
+				include.writeSource(&b)
+			}
+		}
+	}
 
-	b.WriteString(fmt.Sprintf(".program %s\n", a.name))
+	write(a.token, fmt.Sprintf(".program %s\n", a.name))
+	if a.pioVersion != nil {
+		write(a.pioVersion.token, a.pioVersion.ToSource()+"\n")
+	}
+	if a.origin != nil {
+		write(a.origin.token, a.origin.ToSource()+"\n")
+	}
+	if a.sideSet != nil {
+		write(a.sideSet.token, a.sideSet.ToSource()+"\n")
+	}
+	if a.in != nil {
+		write(a.in.token, a.in.ToSource()+"\n")
+	}
+	if a.out != nil {
+		write(a.out.token, a.out.ToSource()+"\n")
+	}
+	if a.set != nil {
+		write(a.set.token, a.set.ToSource()+"\n")
+	}
+	if a.fifo != nil {
+		write(a.fifo.token, a.fifo.ToSource()+"\n")
+	}
+	if a.movStatus != nil {
+		write(a.movStatus.token, a.movStatus.ToSource()+"\n")
+	}
+	if a.clockDiv != nil {
+		write(a.clockDiv.token, a.clockDiv.ToSource()+"\n")
+	}
+	for _, langOpt := range a.langOpts {
+		write(langOpt.token, langOpt.ToSource()+"\n")
+	}
 	for _, define := range a.defines {
-		b.WriteString(define.ToSource() + "\n")
+		write(define.token, define.ToSource()+"\n")
+	}
+	for i, instruction := range a.instructions {
+		writeIncludes(i)
+		if a.wrapTargetDirective != nil && a.wrapTargetDirective.index == i {
+			write(a.wrapTargetDirective.token, a.wrapTargetDirective.ToSource()+"\n")
+		}
+		for _, label := range a.labels {
+			if label.index == i {
+				write(label.token, label.ToSource()+"\n")
+			}
+		}
+		write(instruction.token, "\t"+instruction.ToSource()+"\n")
+		if a.wrapDirective != nil && a.wrapDirective.index == i {
+			write(a.wrapDirective.token, a.wrapDirective.ToSource()+"\n")
+		}
+	}
+	for _, label := range a.labels {
+		if label.index == len(a.instructions) {
+			write(label.token, label.ToSource()+"\n")
+		}
 	}
+	for _, codeBlock := range a.codeBlocks {
+		write(codeBlock.token, codeBlock.ToSource())
+	}
+	// The includes after the last instruction, which may open another program
+	writeIncludes(len(a.instructions))
 
 	return b.String()
 }
 
+// AstLabel names the offset of the following instruction in the program.
+type AstLabel struct {
+	token  *lexItem
+	public bool
+	name   string
+	index  int
+	// instruction is the instruction following the label on the same line, if any
+	instruction *AstInstruction
+}
+
+func (a *AstLabel) ToSource() string {
+	if a.public {
+		return fmt.Sprintf("public %s:", a.name)
+	}
+
+	return a.name + ":"
+}
+
+// parseLabel registers the label as a define of the program so that expressions can refer to it.
+func (c *compiler) parseLabel(l line) *AstLabel {
+	program := c.programDirective(l)
+
+	ast := &AstLabel{token: l[0], index: len(program.instructions)}
+	if l[0].typ == itemPublic {
+		if len(l) < 2 || l[1].typ != itemLabel {
+			c.raiseError("Expected a label", l[0])
+		}
+		ast.public = true
+		l = l[1:]
+	}
+	ast.name = strings.TrimSuffix(l[0].val, ":")
+	if isReserved(ast.name) {
+		c.raiseError(fmt.Sprintf("Reserved word `%s` can't be a label", ast.name), l[0])
+	}
+	c.registerDefine(&AstDefine{token: l[0], name: ast.name, expr: &AstValue{token: l[0], value: pioInt(ast.index)}},
+		l[0])
+
+	if len(l) > 1 && l[1].typ != itemEOF {
+		ast.instruction = c.parseInstruction(l[1:])
+	}
+
+	return ast
+}
+
 type AstDefine struct {
+	token     *lexItem
+	public    bool
 	name      string
 	expr      AstExpr
 	evaluated bool
@@ -81,8 +364,16 @@ type AstDefine struct {
 }
 
 func (a *AstDefine) ToSource() string {
-	result := fmt.Sprintf(".define %s %s", a.name, a.expr.ToSource())
-	_, ok := a.expr.(*AstBinOp)
+	result := ".define "
+	if a.public {
+		result += "public "
+	}
+	result += fmt.Sprintf("%s %s", a.name, a.expr.ToSource())
+	var ok bool
+	switch a.expr.(type) {
+	case *AstBinOp, *AstUnaryOp:
+		ok = true
+	}
 	if a.evaluated && ok {
 		result += fmt.Sprintf(" ; = %d", a.value)
 	}
@@ -90,8 +381,63 @@ func (a *AstDefine) ToSource() string {
 	return result
 }
 
+// AstCodeBlock is the code between `% <lang> {` and `%}` copied verbatim to the output for the language.
+type AstCodeBlock struct {
+	token *lexItem
+	lang  string
+	code  string
+}
+
+func (a *AstCodeBlock) ToSource() string {
+	return fmt.Sprintf("%% %s {\n%s%%}\n", a.lang, a.code)
+}
+
+// codeBlocks returns the code blocks for the language.
+func codeBlocks(blocks []*AstCodeBlock, lang string) []*AstCodeBlock {
+	result := make([]*AstCodeBlock, 0)
+	for _, block := range blocks {
+		if block.lang == lang {
+			result = append(result, block)
+		}
+	}
+
+	return result
+}
+
+func (c *compiler) parseCodeBlock(l line) *AstCodeBlock {
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ip.end()
+
+	text := l[0].val
+	header := text[:strings.IndexByte(text, '\n')]
+	lang := strings.TrimSpace(strings.TrimPrefix(header, "%"))
+	if !strings.HasSuffix(lang, "{") || strings.TrimSpace(strings.TrimSuffix(lang, "{")) == "" {
+		c.raiseError("Expected `% <lang> {`", l[0])
+	}
+	// The code ends with the line of `%}`
+	code := text[len(header)+1 : strings.LastIndexByte(text, '\n')+1]
+
+	return &AstCodeBlock{token: l[0], lang: strings.TrimSpace(strings.TrimSuffix(lang, "{")), code: code}
+}
+
 func Compile(source string, options *Options) (astFile *AstFile, error *CompileError) {
-	lexer, _ := lex("lex", source)
+	lexer, _ := lex("", source)
+
+	return compile(lexer, options)
+}
+
+// CompileFile compiles the file. The errors name the file, or the included file they are in.
+func CompileFile(path string, options *Options) (*AstFile, *CompileError) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &CompileError{file: path, message: "Cannot read the file"}
+	}
+	lexer, _ := lex(path, string(source))
+
+	return compile(lexer, options)
+}
+
+func compile(lexer *lexer, options *Options) (astFile *AstFile, error *CompileError) {
 	c := compiler{
 		options:        options,
 		lex:            lexer,
@@ -103,24 +449,47 @@ func Compile(source string, options *Options) (astFile *AstFile, error *CompileE
 	}
 
 	defer func() {
-		if err, ok := recover().(*CompileError); ok && err != nil {
+		if r := recover(); r != nil {
+			err, ok := r.(*CompileError)
+			if !ok {
+				panic(r)
+			}
 			error = err
 			astFile = nil
 		}
 	}()
 
 	astFile = c.parseFile()
+	c.resolveDefines(astFile)
+	c.assemble(astFile)
+	astFile.warnings = c.warnings
 
 	return
 }
 
+// next returns the next item of the file being parsed. At the end of an included file it continues with
+// the including file, ending the last line of the included one if needed.
 func (c *compiler) next() *lexItem {
-	item, ok := <-c.lex.items
-	if !ok {
-		return nil
-	}
+	for {
+		item, ok := <-c.lex.items
+		if len(c.includes) == 0 {
+			if !ok {
+				return nil
+			}
+			c.lastItem = item.typ
+			return &item
+		}
+		if ok && item.typ != itemEOF {
+			c.lastItem = item.typ
+			return &item
+		}
 
-	return &item
+		c.endInclude()
+		if c.lastItem != itemEOL {
+			c.lastItem = itemEOL
+			return &lexItem{typ: itemEOL, start: item.start, end: item.end, lex: item.lex}
+		}
+	}
 }
 
 func (c *compiler) backup() {
@@ -140,12 +509,12 @@ func (c *compiler) nextLine() line {
 
 func (c *compiler) parseProgram(l line) *AstProgram {
 	var id string
-	if l[0].typ == itemDirProgram && l[1].typ == itemSymbol {
+	if len(l) > 1 && l[0].typ == itemDirProgram && l[1].typ == itemSymbol {
 		id = l[1].val
 	} else {
 		c.raiseError("Syntax error near .program", l[0])
 	}
-	ast := &AstProgram{name: id}
+	ast := &AstProgram{token: l[0], name: id}
 	c.registerProgram(ast, l[0])
 	return ast
 }
@@ -177,22 +546,21 @@ func (c *compiler) parseNumber(item *lexItem) int {
 func (c *compiler) parseDefine(l line) *AstDefine {
 	var name string
 	var value AstExpr
+	var public bool
 
 	// TODO ensure if expressions always needs parents around. If so the matching should be simpler.
 	// e.g. l[3].typ == itemLParen && l[len(l) - 1].typ == itemRParen
-	if l[0].typ == itemDirDefine && l[1].typ == itemPublic && l[2].typ == itemSymbol {
+	if len(l) > 3 && l[0].typ == itemDirDefine && l[1].typ == itemPublic && l[2].typ == itemSymbol {
 		name = c.parseSymbol(l[2])
 		value = c.parseExpr(l[3:])
-	} else if l[0].typ == itemDirDefine && l[1].typ == itemSymbol {
+		public = true
+	} else if len(l) > 2 && l[0].typ == itemDirDefine && l[1].typ == itemSymbol {
 		name = c.parseSymbol(l[1])
 		value = c.parseExpr(l[2:])
 	} else {
 		c.raiseError("Syntax error near `.define`", l[0])
 	}
-	ast := &AstDefine{name: name, expr: value}
-
-	// NOTE don't swap these to lines. It may allow for self-reference
-	c.evaluateDefine(ast)
+	ast := &AstDefine{token: l[0], public: public, name: name, expr: value}
 	c.registerDefine(ast, l[0])
 
 	return ast
@@ -213,22 +581,85 @@ func (c *compiler) registerDefine(define *AstDefine, item *lexItem) {
 	}
 }
 
+// resolveDefines checks that every identifier used by a define is declared
+// and evaluates all of them or, if Options.LazyDefines is set, only the public ones.
+func (c *compiler) resolveDefines(file *AstFile) {
+	for _, define := range file.defines {
+		c.resolveExpr(define.expr)
+	}
+	for _, program := range file.programs {
+		for _, define := range program.defines {
+			c.resolveExpr(define.expr)
+		}
+	}
+
+	// Public defines are always evaluated as their values are exported by the outputs
+	for _, define := range file.defines {
+		if !c.options.LazyDefines || define.public {
+			c.evaluateDefine(define)
+		}
+	}
+	for _, program := range file.programs {
+		for _, define := range program.defines {
+			if !c.options.LazyDefines || define.public {
+				c.evaluateDefine(define)
+			}
+		}
+	}
+}
+
+func (c *compiler) resolveExpr(expr AstExpr) {
+	switch v := expr.(type) {
+	case *AstIdentifier:
+		if c.getDefineDeclaredIn(v.program, v.name) == nil {
+			c.raiseError("Unknown identifier in expression", v.token)
+		}
+	case *AstUnaryOp:
+		c.resolveExpr(v.expr)
+	case *AstBinOp:
+		c.resolveExpr(v.left)
+		c.resolveExpr(v.right)
+	}
+}
+
 func (c *compiler) evaluateDefine(define *AstDefine) {
+	if define.evaluated {
+		return
+	}
+
+	for i, d := range c.evaluating {
+		if d == define {
+			var path bytes.Buffer
+			for _, d := range c.evaluating[i:] {
+				path.WriteString(d.name + " -> ")
+			}
+			path.WriteString(define.name)
+			c.raiseError(fmt.Sprintf("Cyclic definition: %s", path.String()), c.evaluating[i].token)
+		}
+	}
+
+	c.evaluating = append(c.evaluating, define)
 	define.value = define.expr.eval(c)
 	define.evaluated = true
+	c.evaluating = c.evaluating[:len(c.evaluating)-1]
 }
 
 func (c *compiler) getDefineDeclared(name string) *AstDefine {
+	return c.getDefineDeclaredIn(c.currentProgram, name)
+}
+
+// getDefineDeclaredIn looks the define up in the global scope and the scope of the program.
+func (c *compiler) getDefineDeclaredIn(program *AstProgram, name string) *AstDefine {
 	for k := range c.globalSymbols {
 		if name == k {
 			return c.globalSymbols[name]
 		}
 	}
 
-	if c.currentProgram != nil {
-		for k := range c.programSymbols[c.currentProgram.name] {
+	if program != nil {
+		for k := range c.programSymbols[program.name] {
 			if name == k {
-				return c.programSymbols[c.currentProgram.name][k]
+				return c.programSymbols[program.name][k]
 			}
 		}
 	}
@@ -236,11 +667,9 @@ func (c *compiler) getDefineDeclared(name string) *AstDefine {
 	return nil
 }
 
-func (c *compiler) getValueByIdentifier(name string) pioInt {
-	define := c.getDefineDeclared(name)
-	if !define.evaluated {
-		c.evaluateDefine(define)
-	}
+func (c *compiler) getValueByIdentifier(identifier *AstIdentifier) pioInt {
+	define := c.getDefineDeclaredIn(identifier.program, identifier.name)
+	c.evaluateDefine(define)
 
 	return define.value
 }
@@ -256,6 +685,35 @@ func (c *compiler) parseLine() (interface{}, line) {
 		return c.parseProgram(l), l
 	case itemDirDefine:
 		return c.parseDefine(l), l
+	case itemDirSideSet:
+		return c.parseSideSet(l), l
+	case itemDirPioVersion:
+		return c.parsePioVersion(l), l
+	case itemDirWrapTarget, itemDirWrap:
+		return c.parseWrap(l), l
+	case itemDirIn, itemDirOut:
+		return c.parseShiftConfig(l), l
+	case itemDirSet:
+		return c.parseSetConfig(l), l
+	case itemDirFifo:
+		return c.parseFifoConfig(l), l
+	case itemDirMovStatus:
+		return c.parseMovStatus(l), l
+	case itemDirClockDiv:
+		return c.parseClockDiv(l), l
+	case itemDirOrigin:
+		return c.parseOrigin(l), l
+	case itemDirLangOpt:
+		return c.parseLangOpt(l), l
+	case itemDirInclude:
+		return c.parseInclude(l), l
+	case itemCodeBlock:
+		return c.parseCodeBlock(l), l
+	case itemLabel, itemPublic:
+		return c.parseLabel(l), l
+	case itemInstrJMP, itemInstrWAIT, itemInstrIN, itemInstrOUT, itemInstrPUSH, itemInstrPULL, itemInstrMOV,
+		itemInstrIRQ, itemInstrSET, itemInstrNOP:
+		return c.parseInstruction(l), l
 	case itemEOF:
 		return nil, l
 	default:
@@ -267,6 +725,9 @@ func (c *compiler) parseLine() (interface{}, line) {
 func (c *compiler) parseFile() *AstFile {
 	programs := make([]*AstProgram, 0)
 	fileDefines := make([]*AstDefine, 0)
+	fileCodeBlocks := make([]*AstCodeBlock, 0)
+	fileIncludes := make([]*AstInclude, 0)
+	var filePioVersion *AstPioVersion
 
 	for ast, _ := c.parseLine(); ast != nil; ast, _ = c.parseLine() {
 		switch v := ast.(type) {
@@ -279,23 +740,91 @@ func (c *compiler) parseFile() *AstFile {
 			}
 		case *AstProgram:
 			programs = append(programs, v)
+		case *AstSideSet:
+			c.currentProgram.sideSet = v
+		case *AstWrap:
+			if v.token.typ == itemDirWrapTarget {
+				c.currentProgram.wrapTargetDirective = v
+			} else {
+				c.currentProgram.wrapDirective = v
+			}
+		case *AstShiftConfig:
+			if v.token.typ == itemDirIn {
+				c.currentProgram.in = v
+			} else {
+				c.currentProgram.out = v
+			}
+		case *AstSetConfig:
+			c.currentProgram.set = v
+		case *AstFifoConfig:
+			c.currentProgram.fifo = v
+		case *AstMovStatus:
+			c.currentProgram.movStatus = v
+		case *AstClockDiv:
+			c.currentProgram.clockDiv = v
+		case *AstOrigin:
+			c.currentProgram.origin = v
+		case *AstLabel:
+			c.currentProgram.labels = append(c.currentProgram.labels, v)
+			if v.instruction != nil {
+				c.currentProgram.instructions = append(c.currentProgram.instructions, v.instruction)
+			}
+		case *AstCodeBlock:
+			if c.currentProgram != nil {
+				c.currentProgram.codeBlocks = append(c.currentProgram.codeBlocks, v)
+			} else {
+				fileCodeBlocks = append(fileCodeBlocks, v)
+			}
+		case *AstLangOpt:
+			c.currentProgram.langOpts = append(c.currentProgram.langOpts, v)
+		case *AstPioVersion:
+			if c.currentProgram != nil {
+				c.currentProgram.pioVersion = v
+			} else if filePioVersion != nil {
+				c.raiseError("PIO version already defined", v.token)
+			} else {
+				filePioVersion = v
+			}
+		case *AstInstruction:
+			c.currentProgram.instructions = append(c.currentProgram.instructions, v)
+		case *AstInclude:
+			if v.program != nil {
+				v.program.includes = append(v.program.includes, v)
+			} else {
+				fileIncludes = append(fileIncludes, v)
+			}
 		}
 	}
 
 	result := AstFile{
-		defines:  fileDefines,
-		programs: programs,
+		pioVersion: filePioVersion,
+		includes:   fileIncludes,
+		defines:    fileDefines,
+		codeBlocks: fileCodeBlocks,
+		programs:   programs,
 	}
 
 	return &result
 }
 
-func (c *compiler) position(pos int) (line int, offset int) {
-	return c.lex.position(pos)
+// position returns the file, the line and the offset of the item.
+func (c *compiler) position(item *lexItem) (file string, line int, offset int) {
+	lex := item.lex
+	if lex == nil {
+		lex = c.lex
+	}
+	line, offset = lex.position(item.start)
+
+	return lex.name, line, offset
 }
 
 func (c *compiler) raiseError(message string, item *lexItem) {
-	line, offset := c.position(item.start)
-	c.error = &CompileError{message: message, line: line, offset: offset}
+	file, line, offset := c.position(item)
+	c.error = &CompileError{file: file, message: message, line: line, offset: offset}
 	panic(c.error)
 }
+
+func (c *compiler) raiseWarning(message string, item *lexItem) {
+	file, line, offset := c.position(item)
+	c.warnings = append(c.warnings, &CompileError{file: file, message: message, line: line, offset: offset})
+}
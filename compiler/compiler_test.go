@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -13,7 +14,7 @@ func Test_Compile(t *testing.T) {
 		sourceOut := ast.ToSource()
 
 		if sourceOut != `.define A 1
-.define BBB 2
+.define public BBB 2
 ` {
 			t.Logf(sourceOut)
 			t.Errorf("Regenerated source is different")
@@ -65,6 +66,41 @@ func Test_Compile(t *testing.T) {
 		}
 	})
 
+	t.Run("Error if label is a reserved word.", func(t *testing.T) {
+		for _, label := range []string{"next", "x", "left", "block"} {
+			source := `
+.program test
+` + label + `:
+	jmp ` + label
+
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%#v", ast)
+			}
+			if e == nil || e.message != "Reserved word `"+label+"` can't be a label" || e.line != 3 || e.offset != 1 {
+				t.Errorf("%s: %#v", label, e)
+			}
+		}
+	})
+
+	t.Run("Error if rune is unknown.", func(t *testing.T) {
+		for line, offset := range map[string]int{"	:": 2, "	nop >": 6, "	# nop": 2} {
+			source := `
+.program test
+` + line
+
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%#v", ast)
+			}
+			if e == nil || e.message != "Unexpected item" || e.line != 3 || e.offset != offset {
+				t.Errorf("%s: %#v", line, e)
+			}
+		}
+	})
+
 	t.Run("Error if redeclare .define. Case 1.", func(t *testing.T) {
 		source := `
 .define A 1
@@ -198,7 +234,7 @@ func Test_Compile(t *testing.T) {
 		if sourceOut != `.define A 1
 .program test
 .define B 1
-.define BBB 2
+.define public BBB 2
 ` {
 			t.Logf(sourceOut)
 			t.Errorf("Regenerated source is different")
@@ -227,4 +263,265 @@ func Test_Compile(t *testing.T) {
 		}
 	})
 
+	t.Run("Leaves defines symbolic if not evaluated.", func(t *testing.T) {
+		source := `
+.define A 60
+.define B A + 13
+.define C 1 / 0
+`
+
+		ast, e := Compile(source, &Options{LazyDefines: true})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A 60
+.define B A + 13
+.define C 1 / 0
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+		if ast.defines[1].evaluated {
+			t.Errorf("%#v", ast.defines[1])
+		}
+	})
+
+	t.Run("Evaluates every define by default.", func(t *testing.T) {
+		source := `
+.define A 60
+.define C 1 / 0
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Division by zero" || e.line != 3 || e.offset != 13 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if forward reference is not allowed.", func(t *testing.T) {
+		source := `
+.define A B + 1
+.define B 2
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Unknown identifier in expression" || e.line != 2 || e.offset != 11 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Evaluates forward references.", func(t *testing.T) {
+		source := `
+.define A B + 1
+.define B 2
+
+.program test
+.define C A * D
+.define D B + 2
+`
+
+		ast, e := Compile(source, &Options{ForwardRefs: true})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A B + 1 ; = 3
+.define B 2
+.program test
+.define C A * D ; = 12
+.define D B + 2 ; = 4
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Error if forward reference is unknown.", func(t *testing.T) {
+		source := `
+.program testA
+.define A B + 1
+
+.program testB
+.define B 2
+`
+
+		ast, e := Compile(source, &Options{ForwardRefs: true})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Unknown identifier in expression" || e.line != 3 || e.offset != 11 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if defines are cyclic. Case 1.", func(t *testing.T) {
+		source := `
+.define A 1
+.define B C + A
+.define C D * 2
+.define D B
+`
+
+		ast, e := Compile(source, &Options{ForwardRefs: true})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Cyclic definition: B -> C -> D -> B" || e.line != 3 || e.offset != 1 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if defines are cyclic. Case 2.", func(t *testing.T) {
+		source := `
+.define A A + 1
+`
+
+		ast, e := Compile(source, &Options{ForwardRefs: true})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Cyclic definition: A -> A" || e.line != 2 || e.offset != 1 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Keeps code blocks verbatim.", func(t *testing.T) {
+		source := `
+% c-sdk {
+#include "hardware/clocks.h"
+%}
+.program blink
+	nop
+% c-sdk {
+static inline void blink_program_init(PIO pio, uint sm, uint offset, uint pin) {
+    // .wrap_target; mov x, y [31]
+    pio_gpio_init(pio, pin);
+}
+  %}
+%python{
+print("50%")
+%}
+	nop x
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		// Lines are still counted across the code blocks
+		if e == nil || e.message != "Unexpected item" || e.line != 16 || e.offset != 6 {
+			t.Errorf("%#v", e)
+		}
+
+		ast, e = Compile(strings.Replace(source, "nop x", "nop", 1), &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+		if len(ast.codeBlocks) != 1 || ast.codeBlocks[0].lang != "c-sdk" ||
+			ast.codeBlocks[0].code != "#include \"hardware/clocks.h\"\n" {
+			t.Errorf("%#v", ast.codeBlocks)
+		}
+		blocks := ast.programs[0].codeBlocks
+		if len(blocks) != 2 || blocks[0].lang != "c-sdk" || blocks[1].lang != "python" {
+			t.Fatalf("%#v", blocks)
+		}
+		if blocks[0].code != `static inline void blink_program_init(PIO pio, uint sm, uint offset, uint pin) {
+    // .wrap_target; mov x, y [31]
+    pio_gpio_init(pio, pin);
+}
+` {
+			t.Errorf("%s", blocks[0].code)
+		}
+		if blocks[1].code != "print(\"50%\")\n" {
+			t.Errorf("%s", blocks[1].code)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `% c-sdk {
+#include "hardware/clocks.h"
+%}
+.program blink
+	nop
+	nop
+% c-sdk {
+static inline void blink_program_init(PIO pio, uint sm, uint offset, uint pin) {
+    // .wrap_target; mov x, y [31]
+    pio_gpio_init(pio, pin);
+}
+%}
+% python {
+print("50%")
+%}
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Error if a code block is invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{"% c-sdk {\nint x;\n", "Unexpected item", 1, 1},
+			{"% c-sdk\nint x;\n%}\n", "Expected `% <lang> {`", 1, 1},
+			{"% {\nint x;\n%}\n", "Expected `% <lang> {`", 1, 1},
+			{"% c-sdk {\n%} nop\n", "Unexpected item", 2, 4},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Maps instructions to source lines.", func(t *testing.T) {
+		source := `.program test
+.side_set 1
+	set x, 1 side 1
+
+loop: jmp loop side 0 [3]
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+		program := ast.Program("test")
+		if program.SourceLine(0) != 3 || program.SourceLine(1) != 5 || program.SourceLine(2) != 0 {
+			t.Errorf("%v", program.sourceLines)
+		}
+		if got := program.Disassemble(program.Instructions()[1]); got != "jmp    1               side 0 [3]" {
+			t.Errorf("`%s`", got)
+		}
+	})
 }
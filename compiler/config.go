@@ -0,0 +1,503 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AstWrap is `.wrap_target` or `.wrap`.
+type AstWrap struct {
+	token *lexItem
+	// index of the instruction following `.wrap_target` or preceding `.wrap`
+	index int
+}
+
+func (a *AstWrap) ToSource() string {
+	return a.token.val
+}
+
+// AstShiftConfig is `.in` or `.out`: the pin count and the configuration of the shift register.
+type AstShiftConfig struct {
+	token *lexItem
+	count AstExpr
+	// direction is itemLeft, itemRight or 0 if omitted
+	direction itemType
+	auto      bool
+	// threshold is nil if omitted
+	threshold AstExpr
+	// Evaluated while assembling
+	pins int
+	bits int
+}
+
+func (a *AstShiftConfig) ToSource() string {
+	result := fmt.Sprintf("%s %s", a.token.val, a.count.ToSource())
+	switch a.direction {
+	case itemLeft:
+		result += " left"
+	case itemRight:
+		result += " right"
+	}
+	if a.auto {
+		result += " auto"
+	}
+	if a.threshold != nil {
+		result += " " + a.threshold.ToSource()
+	}
+
+	return result
+}
+
+// shiftRight tells the direction of the shift, which is right unless specified.
+func (a *AstShiftConfig) shiftRight() bool {
+	return a.direction != itemLeft
+}
+
+// AstSetConfig is `.set`, the number of pins written by `set`.
+type AstSetConfig struct {
+	token *lexItem
+	count AstExpr
+	// Evaluated while assembling
+	pins int
+}
+
+func (a *AstSetConfig) ToSource() string {
+	return fmt.Sprintf(".set %s", a.count.ToSource())
+}
+
+// AstFifoConfig is `.fifo`, the join mode of the FIFOs.
+type AstFifoConfig struct {
+	token *lexItem
+	mode  *lexItem
+}
+
+func (a *AstFifoConfig) ToSource() string {
+	return ".fifo " + fifoModes[a.mode.typ]
+}
+
+var fifoModes = map[itemType]string{
+	itemTxRx: "txrx",
+	itemTx:   "tx",
+	itemRx:   "rx",
+	// PIO version 1
+	itemTxPut:  "txput",
+	itemTxGet:  "txget",
+	itemPutGet: "putget",
+}
+
+// AstMovStatus is `.mov_status`, the source of `mov x, status`.
+type AstMovStatus struct {
+	token *lexItem
+	// source is itemTxFifo, itemRxFifo or itemInstrIRQ
+	source    itemType
+	index     AstExpr
+	indexMode irqIndexMode
+	// pioV1 is the `irq` item, nil for the FIFO levels
+	pioV1 *lexItem
+	// Evaluated while assembling
+	value int
+}
+
+func (a *AstMovStatus) ToSource() string {
+	switch a.source {
+	case itemTxFifo:
+		return fmt.Sprintf(".mov_status txfifo < %s", a.index.ToSource())
+	case itemRxFifo:
+		return fmt.Sprintf(".mov_status rxfifo < %s", a.index.ToSource())
+	}
+
+	result := ".mov_status irq "
+	switch a.indexMode {
+	case irqIndexPrev:
+		result += "prev "
+	case irqIndexNext:
+		result += "next "
+	}
+
+	return result + "set " + a.index.ToSource()
+}
+
+// AstClockDiv is `.clock_div`, the divider of the system clock.
+type AstClockDiv struct {
+	token   *lexItem
+	value   *lexItem
+	divider float64
+}
+
+func (a *AstClockDiv) ToSource() string {
+	return ".clock_div " + a.value.val
+}
+
+// AstOrigin is `.origin`, the offset the program must be loaded at.
+type AstOrigin struct {
+	token  *lexItem
+	offset AstExpr
+	// Evaluated while assembling
+	value int
+}
+
+func (a *AstOrigin) ToSource() string {
+	return ".origin " + a.offset.ToSource()
+}
+
+// AstLangOpt is `.lang_opt`, an option passed verbatim to the output for the language.
+type AstLangOpt struct {
+	token *lexItem
+	lang  string
+	name  string
+	// value is the source text following `=`
+	value string
+}
+
+func (a *AstLangOpt) ToSource() string {
+	return fmt.Sprintf(".lang_opt %s %s = %s", a.lang, a.name, a.value)
+}
+
+// programDirective returns the current program or raises an error if the directive is outside of a program.
+func (c *compiler) programDirective(l line) *AstProgram {
+	if c.currentProgram == nil {
+		c.raiseError(fmt.Sprintf("`%s` outside of a program", l[0].val), l[0])
+	}
+
+	return c.currentProgram
+}
+
+func (c *compiler) parseWrap(l line) *AstWrap {
+	program := c.programDirective(l)
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ip.end()
+
+	if l[0].typ == itemDirWrapTarget {
+		if program.wrapTargetDirective != nil {
+			c.raiseError("`.wrap_target` already specified", l[0])
+		}
+		return &AstWrap{token: l[0], index: len(program.instructions)}
+	}
+
+	if program.wrapDirective != nil {
+		c.raiseError("`.wrap` already specified", l[0])
+	}
+	if len(program.instructions) == 0 {
+		c.raiseError("`.wrap` must follow an instruction", l[0])
+	}
+
+	return &AstWrap{token: l[0], index: len(program.instructions) - 1}
+}
+
+func (c *compiler) parseShiftConfig(l line) *AstShiftConfig {
+	program := c.programDirective(l)
+	if l[0].typ == itemDirIn && program.in != nil || l[0].typ == itemDirOut && program.out != nil {
+		c.raiseError(fmt.Sprintf("`%s` already specified", l[0].val), l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstShiftConfig{token: l[0], count: ip.expr()}
+	if ip.accept(itemLeft) != nil {
+		ast.direction = itemLeft
+	} else if ip.accept(itemRight) != nil {
+		ast.direction = itemRight
+	}
+	if ip.accept(itemAuto) != nil {
+		ast.auto = true
+	}
+	if ip.peek() != itemEOL {
+		ast.threshold = ip.expr()
+	}
+	ip.end()
+
+	return ast
+}
+
+func (c *compiler) parseSetConfig(l line) *AstSetConfig {
+	program := c.programDirective(l)
+	if program.set != nil {
+		c.raiseError("`.set` already specified", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstSetConfig{token: l[0], count: ip.expr()}
+	ip.end()
+
+	return ast
+}
+
+func (c *compiler) parseFifoConfig(l line) *AstFifoConfig {
+	program := c.programDirective(l)
+	if program.fifo != nil {
+		c.raiseError("`.fifo` already specified", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	message := "Expected `txrx`, `tx`, `rx`, `txput`, `txget` or `putget`"
+	if ip.peek() == itemEOL {
+		c.raiseError(message, l[0])
+	}
+	ast := &AstFifoConfig{token: l[0], mode: ip.next()}
+	if _, ok := fifoModes[ast.mode.typ]; !ok {
+		c.raiseError(message, ast.mode)
+	}
+	ip.end()
+
+	return ast
+}
+
+func (c *compiler) parseMovStatus(l line) *AstMovStatus {
+	program := c.programDirective(l)
+	if program.movStatus != nil {
+		c.raiseError("`.mov_status` already specified", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstMovStatus{token: l[0]}
+	message := "Expected `txfifo`, `rxfifo` or `irq`"
+	if ip.peek() == itemEOL {
+		c.raiseError(message, l[0])
+	}
+	switch lexItem := ip.next(); lexItem.typ {
+	case itemTxFifo, itemRxFifo:
+		ast.source = lexItem.typ
+		ip.expect(itemLess, "Expected `<`")
+	case itemInstrIRQ:
+		ast.source = itemInstrIRQ
+		ast.pioV1 = lexItem
+		if ip.accept(itemPrev) != nil {
+			ast.indexMode = irqIndexPrev
+		} else if ip.accept(itemNext) != nil {
+			ast.indexMode = irqIndexNext
+		}
+		ip.expect(itemInstrSET, "Expected `set`")
+	default:
+		c.raiseError(message, lexItem)
+	}
+	ast.index = ip.expr()
+	ip.end()
+
+	return ast
+}
+
+func (c *compiler) parseClockDiv(l line) *AstClockDiv {
+	program := c.programDirective(l)
+	if program.clockDiv != nil {
+		c.raiseError("`.clock_div` already specified", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstClockDiv{token: l[0]}
+	switch ip.peek() {
+	case itemNumber, itemFloat:
+		ast.value = ip.next()
+	default:
+		c.raiseError("Expected the clock divider", ip.last)
+	}
+	ip.end()
+
+	divider, err := strconv.ParseFloat(ast.value.val, 64)
+	if err != nil || divider < 1 || divider > 65536 {
+		c.raiseError("Clock divider must be in 1..65536", ast.value)
+	}
+	ast.divider = divider
+
+	return ast
+}
+
+func (c *compiler) parseOrigin(l line) *AstOrigin {
+	program := c.programDirective(l)
+	if program.origin != nil {
+		c.raiseError("`.origin` already specified", l[0])
+	}
+	if len(program.instructions) > 0 {
+		c.raiseError("`.origin` must precede the instructions", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstOrigin{token: l[0], offset: ip.expr()}
+	ip.end()
+
+	return ast
+}
+
+// parseLangOpt parses `.lang_opt <lang> <name> = <value>`. The language, e.g. `c-sdk`, and the value
+// are taken from the source as they aren't made of the items of the assembler.
+func (c *compiler) parseLangOpt(l line) *AstLangOpt {
+	c.programDirective(l)
+	if l[len(l)-1].typ == itemEOF {
+		l = l[:len(l)-1]
+	}
+
+	equal := 0
+	for i, lexItem := range l {
+		if lexItem.typ == itemEqual {
+			equal = i
+			break
+		}
+	}
+	if equal < 3 || equal == len(l)-1 {
+		c.raiseError("Syntax error near `.lang_opt`", l[0])
+	}
+	// The name may be a keyword of the assembler, e.g. `pins`
+	name := l[equal-1]
+	if !isSymbol([]rune(name.val)[0]) {
+		c.raiseError("Expected the option name", name)
+	}
+
+	return &AstLangOpt{
+		token: l[0],
+		lang:  l[0].lex.input[l[1].start:l[equal-2].end],
+		name:  name.val,
+		value: l[0].lex.input[l[equal+1].start:l[len(l)-1].end],
+	}
+}
+
+// assembleConfig evaluates and checks the state machine configuration of the program.
+func (c *compiler) assembleConfig(program *AstProgram) {
+	if wrapTarget := program.wrapTargetDirective; wrapTarget != nil && wrapTarget.index == len(program.instructions) {
+		c.raiseError("`.wrap_target` must precede an instruction", wrapTarget.token)
+	}
+
+	if origin := program.origin; origin != nil {
+		origin.value = int(c.evalRange(origin.offset, 0, 31, "`.origin` must be in 0..31"))
+	}
+	if in := program.in; in != nil {
+		in.pins = int(c.evalRange(in.count, 1, 32, "`.in` count must be in 1..32"))
+		if in.pins != 32 && c.pioVersion < 1 {
+			c.raiseError("`.in` count other than 32 requires PIO version 1", in.count.start())
+		}
+		in.bits = c.evalThreshold(in.threshold)
+	}
+	if out := program.out; out != nil {
+		out.pins = int(c.evalRange(out.count, 0, 32, "`.out` count must be in 0..32"))
+		out.bits = c.evalThreshold(out.threshold)
+	}
+	if set := program.set; set != nil {
+		set.pins = int(c.evalRange(set.count, 0, 5, "`.set` count must be in 0..5"))
+	}
+	if fifo := program.fifo; fifo != nil {
+		switch fifo.mode.typ {
+		case itemTxPut, itemTxGet, itemPutGet:
+			c.requirePioV1(fifo.mode)
+		}
+	}
+	if movStatus := program.movStatus; movStatus != nil {
+		c.requirePioV1(movStatus.pioV1)
+		if movStatus.source == itemInstrIRQ {
+			movStatus.value = int(c.encodeIrqIndex(movStatus.index, movStatus.indexMode))
+		} else {
+			movStatus.value = int(c.evalRange(movStatus.index, 0, 15, "FIFO level must be in 0..15"))
+		}
+	}
+}
+
+// evalThreshold returns the autopush or autopull threshold, which is 32 unless specified.
+func (c *compiler) evalThreshold(threshold AstExpr) int {
+	if threshold == nil {
+		return 32
+	}
+
+	return int(c.evalRange(threshold, 1, 32, "Threshold must be in 1..32"))
+}
+
+// FifoJoin is the join mode of the FIFOs set by `.fifo`.
+type FifoJoin int
+
+const (
+	FifoJoinNone FifoJoin = iota
+	FifoJoinTx
+	FifoJoinRx
+	FifoJoinTxPut
+	FifoJoinTxGet
+	FifoJoinPutGet
+)
+
+var fifoJoins = map[itemType]FifoJoin{
+	itemTxRx:   FifoJoinNone,
+	itemTx:     FifoJoinTx,
+	itemRx:     FifoJoinRx,
+	itemTxPut:  FifoJoinTxPut,
+	itemTxGet:  FifoJoinTxGet,
+	itemPutGet: FifoJoinPutGet,
+}
+
+// MovStatus is the source of `mov x, status` set by `.mov_status`.
+type MovStatus int
+
+const (
+	MovStatusTxLessThan MovStatus = iota
+	MovStatusRxLessThan
+	MovStatusIrqSet
+)
+
+var movStatuses = map[itemType]MovStatus{
+	itemTxFifo:   MovStatusTxLessThan,
+	itemRxFifo:   MovStatusRxLessThan,
+	itemInstrIRQ: MovStatusIrqSet,
+}
+
+// SmConfig is the default configuration of a state machine running the program, like
+// `<program>_program_get_default_config` of the c-sdk output. The pins are left to the user.
+type SmConfig struct {
+	WrapTarget int
+	Wrap       int
+	// SideSetCount includes the enable bit of the optional side-set
+	SideSetCount    int
+	SideSetOptional bool
+	SideSetPindirs  bool
+	InCount         int
+	InShiftRight    bool
+	Autopush        bool
+	PushThreshold   int
+	OutCount        int
+	OutShiftRight   bool
+	Autopull        bool
+	PullThreshold   int
+	SetCount        int
+	FifoJoin        FifoJoin
+	MovStatus       MovStatus
+	MovStatusN      int
+	ClockDiv        float64
+}
+
+// DefaultConfig returns the configuration set by the directives over the defaults of the c-sdk.
+func (a *AstProgram) DefaultConfig() SmConfig {
+	result := SmConfig{
+		WrapTarget:    a.wrapTarget(),
+		Wrap:          a.wrap(),
+		InCount:       32,
+		InShiftRight:  true,
+		PushThreshold: 32,
+		OutShiftRight: true,
+		PullThreshold: 32,
+		ClockDiv:      1,
+	}
+	if sideSet := a.sideSet; sideSet != nil {
+		result.SideSetCount = sideSet.bitsIncludingOpt()
+		result.SideSetOptional = sideSet.optional
+		result.SideSetPindirs = sideSet.pindirs
+	}
+	if in := a.in; in != nil {
+		result.InCount = in.pins
+		result.InShiftRight = in.shiftRight()
+		result.Autopush = in.auto
+		result.PushThreshold = in.bits
+	}
+	if out := a.out; out != nil {
+		result.OutCount = out.pins
+		result.OutShiftRight = out.shiftRight()
+		result.Autopull = out.auto
+		result.PullThreshold = out.bits
+	}
+	if set := a.set; set != nil {
+		result.SetCount = set.pins
+	}
+	if fifo := a.fifo; fifo != nil {
+		result.FifoJoin = fifoJoins[fifo.mode.typ]
+	}
+	if movStatus := a.movStatus; movStatus != nil {
+		result.MovStatus = movStatuses[movStatus.source]
+		result.MovStatusN = movStatus.value
+	}
+	if clockDiv := a.clockDiv; clockDiv != nil {
+		result.ClockDiv = clockDiv.divider
+	}
+
+	return result
+}
@@ -0,0 +1,257 @@
+package compiler
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Compile_Config(t *testing.T) {
+	t.Run("Evaluates the configuration directives.", func(t *testing.T) {
+		source := `
+.pio_version 1
+.program test
+.define N 8
+.in 16 left auto N
+.out N right
+.set 5
+.fifo txput
+.mov_status irq next set 2
+.clock_div 2.5
+.origin N * 2
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		program := ast.programs[0]
+		if in := program.in; in.pins != 16 || in.shiftRight() || !in.auto || in.bits != 8 {
+			t.Errorf("in: %#v", in)
+		}
+		if out := program.out; out.pins != 8 || !out.shiftRight() || out.auto || out.bits != 32 {
+			t.Errorf("out: %#v", out)
+		}
+		if program.set.pins != 5 {
+			t.Errorf("set: %#v", program.set)
+		}
+		if program.fifo.mode.typ != itemTxPut {
+			t.Errorf("fifo: %#v", program.fifo)
+		}
+		if program.movStatus.value != 0x1a {
+			t.Errorf("mov_status: %#v", program.movStatus)
+		}
+		if program.clockDiv.divider != 2.5 {
+			t.Errorf("clock_div: %#v", program.clockDiv)
+		}
+		if program.Origin() != 16 {
+			t.Errorf("origin: %#v", program.origin)
+		}
+	})
+
+	t.Run("Places the wrap.", func(t *testing.T) {
+		cases := []struct {
+			source     string
+			wrapTarget int
+			wrap       int
+		}{
+			{"nop\nnop\nnop", 0, 2},
+			{"nop\n.wrap_target\nnop\nnop", 1, 2},
+			{"nop\nnop\n.wrap\nnop", 0, 1},
+			{".wrap_target\nnop\n.wrap\nnop", 0, 0},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			program := ast.programs[0]
+			if program.wrapTarget() != tc.wrapTarget || program.wrap() != tc.wrap {
+				t.Errorf("%s: %d, %d", tc.source, program.wrapTarget(), program.wrap())
+			}
+		}
+	})
+
+	t.Run("Error if the configuration directives are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{".in 32\n", "`.in` outside of a program", 1, 1},
+			{".program test\n.in 16\n", "`.in` count other than 32 requires PIO version 1", 2, 5},
+			{".program test\n.in 0\n", "`.in` count must be in 1..32", 2, 5},
+			{".program test\n.in 32 left 33\n", "Threshold must be in 1..32", 2, 13},
+			{".program test\n.in 32\n.in 32\n", "`.in` already specified", 3, 1},
+			{".program test\n.out 33\n", "`.out` count must be in 0..32", 2, 6},
+			{".program test\n.out 8 auto left\n", "Syntax error in expression", 2, 13},
+			{".program test\n.set 6\n", "`.set` count must be in 0..5", 2, 6},
+			{".program test\n.fifo\n", "Expected `txrx`, `tx`, `rx`, `txput`, `txget` or `putget`", 2, 1},
+			{".program test\n.fifo x\n", "Expected `txrx`, `tx`, `rx`, `txput`, `txget` or `putget`", 2, 7},
+			{".program test\n.fifo putget\n", "`putget` requires PIO version 1", 2, 7},
+			{".program test\n.mov_status txfifo 2\n", "Expected `<`", 2, 20},
+			{".program test\n.mov_status rxfifo < 16\n", "FIFO level must be in 0..15", 2, 22},
+			{".program test\n.mov_status irq set 1\n", "`irq` requires PIO version 1", 2, 13},
+			{".program test\n.pio_version 1\n.mov_status irq 1\n", "Expected `set`", 3, 17},
+			{".program test\n.clock_div 0.5\n", "Clock divider must be in 1..65536", 2, 12},
+			{".program test\n.clock_div x\n", "Expected the clock divider", 2, 1},
+			{".program test\n.origin 32\n", "`.origin` must be in 0..31", 2, 9},
+			{".program test\n.origin 1\n.origin 2\n", "`.origin` already specified", 3, 1},
+			{".program test\nnop\n.origin 2\n", "`.origin` must precede the instructions", 3, 1},
+			{".program test\n.wrap\nnop\n", "`.wrap` must follow an instruction", 2, 1},
+			{".program test\nnop\n.wrap\n.wrap\n", "`.wrap` already specified", 4, 1},
+			{".program test\n.wrap_target\nnop\n.wrap_target\n", "`.wrap_target` already specified", 4, 1},
+			{".program test\nnop\n.wrap_target\n", "`.wrap_target` must precede an instruction", 3, 1},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates the configuration directives.", func(t *testing.T) {
+		source := `
+.program test
+.define N 8
+.clock_div 4
+.origin N
+.set 1 + 1
+.out N left auto
+.in 32 right N / 2
+.fifo tx
+.mov_status txfifo < N - 6
+	nop
+.wrap_target
+	set x, 1
+	nop
+.wrap
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.origin N
+.in 32 right N / 2
+.out N left auto
+.set 1 + 1
+.fifo tx
+.mov_status txfifo < N - 6
+.clock_div 4
+.define N 8
+	nop
+.wrap_target
+	set x, 1
+	nop
+.wrap
+	nop
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Keeps the language options.", func(t *testing.T) {
+		source := `
+.program test
+.lang_opt python sideset_init = pico.PIO.OUT_HIGH
+.lang_opt python out_shiftdir = 1 ; a comment
+.lang_opt c-sdk some_option = (1, 2)
+.lang_opt rust pins=[x, y]
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		want := []AstLangOpt{
+			{lang: "python", name: "sideset_init", value: "pico.PIO.OUT_HIGH"},
+			{lang: "python", name: "out_shiftdir", value: "1"},
+			{lang: "c-sdk", name: "some_option", value: "(1, 2)"},
+			{lang: "rust", name: "pins", value: "[x, y]"},
+		}
+		langOpts := ast.programs[0].langOpts
+		if len(langOpts) != len(want) {
+			t.Fatalf("%d != %d", len(langOpts), len(want))
+		}
+		for i, langOpt := range langOpts {
+			if langOpt.lang != want[i].lang || langOpt.name != want[i].name || langOpt.value != want[i].value {
+				t.Errorf("%d: %#v", i, langOpt)
+			}
+		}
+		if len(ast.warnings) != 0 {
+			t.Errorf("%#v", ast.warnings)
+		}
+	})
+
+	t.Run("Error if a language option is invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{".lang_opt python a = 1\n", "`.lang_opt` outside of a program", 1, 1},
+			{".program test\n.lang_opt python a 1\n", "Syntax error near `.lang_opt`", 2, 1},
+			{".program test\n.lang_opt a = 1\n", "Syntax error near `.lang_opt`", 2, 1},
+			{".program test\n.lang_opt python a =\n", "Syntax error near `.lang_opt`", 2, 1},
+			{".program test\n.lang_opt python 1 = 1\n", "Expected the option name", 2, 18},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates the language options.", func(t *testing.T) {
+		source := `
+.program test
+.lang_opt python  out_init=pico.PIO.OUT_LOW ; Comment
+.lang_opt c-sdk x = 1
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.lang_opt python out_init = pico.PIO.OUT_LOW
+.lang_opt c-sdk x = 1
+	nop
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operand names by their encoding; empty names are reserved encodings.
+var (
+	inSourceNames       = [8]string{"pins", "x", "y", "null", "", "", "isr", "osr"}
+	outDestinationNames = [8]string{"pins", "x", "y", "null", "pindirs", "pc", "isr", "exec"}
+	movDestinationNames = [8]string{"pins", "x", "y", "pindirs", "exec", "pc", "isr", "osr"}
+	movSourceNames      = [8]string{"pins", "x", "y", "null", "", "status", "isr", "osr"}
+	setDestinationNames = [8]string{"pins", "x", "y", "", "pindirs", "", "", ""}
+)
+
+// pythonJmpConditions are the conditions of `jmp` in the MicroPython `rp2` assembler.
+var pythonJmpConditions = [8]string{"", "not_x", "x_dec", "not_y", "y_dec", "x_not_y", "pin", "not_osre"}
+
+// bitCount decodes the bit count of `in` and `out`, where 0 means 32.
+func bitCount(arg2 uint16) uint16 {
+	if arg2 == 0 {
+		return 32
+	}
+
+	return arg2
+}
+
+// pushPullNames returns the name of the instruction and its condition followed by a space, if any.
+func pushPullNames(arg1 uint16) (op string, conditional string) {
+	op = "push"
+	if arg1&0x4 != 0 {
+		op = "pull"
+	}
+	if arg1&0x2 != 0 && op == "push" {
+		conditional = "iffull "
+	} else if arg1&0x2 != 0 {
+		conditional = "ifempty "
+	}
+
+	return
+}
+
+// irqIndexName renders the 5-bit index field of `irq` and `wait irq`.
+func irqIndexName(arg2 uint16) string {
+	index := arg2 & 0x7
+	switch irqIndexMode(arg2 >> 3 & 0x3) {
+	case irqIndexPrev:
+		return fmt.Sprintf("prev %d", index)
+	case irqIndexRel:
+		return fmt.Sprintf("%d rel", index)
+	case irqIndexNext:
+		return fmt.Sprintf("next %d", index)
+	}
+
+	return fmt.Sprintf("%d", index)
+}
+
+// rxFifoName renders `mov rxfifo[]` and `mov osr, rxfifo[]` encoded as `push` and `pull`, or "" if the word is none.
+func rxFifoName(arg1 uint16, arg2 uint16) string {
+	if arg1&0x3 != 0 || arg2&0x14 != 0x10 {
+		return ""
+	}
+	fifo := fmt.Sprintf("rxfifo[%d]", arg2&0x3)
+	if arg2&0x8 == 0 {
+		fifo = "rxfifo[y]"
+	}
+	if arg1&0x4 != 0 {
+		return "osr, " + fifo
+	}
+
+	return fifo + ", isr"
+}
+
+// splitDelaySideSet returns the side-set value, -1 if the instruction has none, and the delay.
+func splitDelaySideSet(word uint16, sideSet *AstSideSet) (side int, delay int) {
+	field := int(word>>8) & 0x1f
+	sideSetBits := sideSet.bitsIncludingOpt()
+	delayBits := 5 - sideSetBits
+	delay = field & (1<<delayBits - 1)
+	side = -1
+	if sideSetBits > 0 && (!sideSet.optional || field&0x10 != 0) {
+		side = field >> delayBits & (1<<sideSet.bits - 1)
+	}
+
+	return
+}
+
+// disassemble renders the instruction word as source, as pioasm does in the comments of the c-sdk output.
+func disassemble(word uint16, sideSet *AstSideSet) string {
+	var op, guts string
+	arg1 := word >> 5 & 0x7
+	arg2 := word & 0x1f
+
+	switch word & 0xe000 {
+	case opcodeJMP:
+		op = "jmp"
+		guts = fmt.Sprintf("%d", arg2)
+		if condition := jmpCondition(arg1); condition == jmpXNotY {
+			guts = fmt.Sprintf("x != y, %d", arg2)
+		} else if condition != jmpAlways {
+			guts = fmt.Sprintf("%s, %d", jmpConditionNames[condition], arg2)
+		}
+	case opcodeWAIT:
+		op = "wait"
+		guts = fmt.Sprintf("%d ", arg1>>2)
+		switch waitSource(arg1 & 0x3) {
+		case waitGPIO:
+			guts += fmt.Sprintf("gpio %d", arg2)
+		case waitPin:
+			guts += fmt.Sprintf("pin %d", arg2)
+		case waitIRQ:
+			guts += "irq " + irqIndexName(arg2)
+		case waitJmpPin:
+			guts += "jmppin"
+			if arg2 != 0 {
+				guts += fmt.Sprintf(" + %d", arg2)
+			}
+		}
+	case opcodeIN:
+		if inSourceNames[arg1] == "" {
+			return "reserved"
+		}
+		op = "in"
+		guts = fmt.Sprintf("%s, %d", inSourceNames[arg1], bitCount(arg2))
+	case opcodeOUT:
+		op = "out"
+		guts = fmt.Sprintf("%s, %d", outDestinationNames[arg1], bitCount(arg2))
+	case opcodePUSHPULL:
+		if arg2 != 0 {
+			op = "mov"
+			if guts = rxFifoName(arg1, arg2); guts == "" {
+				return "reserved"
+			}
+			break
+		}
+		op, guts = pushPullNames(arg1)
+		if arg1&0x1 != 0 {
+			guts += "block"
+		} else {
+			guts += "noblock"
+		}
+	case opcodeMOV:
+		if word&0xff == 0x42 {
+			op = "nop"
+			break
+		}
+		operation := arg2 >> 3
+		source := arg2 & 0x7
+		if movSourceNames[source] == "" || operation > uint16(movReverse) {
+			return "reserved"
+		}
+		op = "mov"
+		guts = fmt.Sprintf("%s, %s%s", movDestinationNames[arg1], movOperationNames[operation], movSourceNames[source])
+	case opcodeIRQ:
+		if arg1&0x4 != 0 {
+			return "reserved"
+		}
+		op = "irq"
+		switch {
+		case arg1&0x2 != 0:
+			guts = "clear "
+		case arg1&0x1 != 0:
+			guts = "wait "
+		default:
+			guts = "nowait "
+		}
+		guts += irqIndexName(arg2)
+	case opcodeSET:
+		if setDestinationNames[arg1] == "" {
+			return "reserved"
+		}
+		op = "set"
+		guts = fmt.Sprintf("%s, %d", setDestinationNames[arg1], arg2)
+	default:
+		return "reserved"
+	}
+
+	result := fmt.Sprintf("%-7s%-16s", op, guts)
+	side, delay := splitDelaySideSet(word, sideSet)
+	if side >= 0 {
+		result += fmt.Sprintf("%-7s", fmt.Sprintf("side %d", side))
+	}
+	if delay > 0 {
+		result += fmt.Sprintf("[%d]", delay)
+	}
+
+	return strings.TrimRight(result, " ")
+}
+
+// disassemblePython renders the instruction word as a call of the MicroPython `rp2` assembler.
+// The targets of `jmp` are labels named after the offset, see jmpTargets.
+func disassemblePython(word uint16, sideSet *AstSideSet) string {
+	var result string
+	arg1 := word >> 5 & 0x7
+	arg2 := word & 0x1f
+
+	switch word & 0xe000 {
+	case opcodeJMP:
+		if condition := jmpCondition(arg1); condition == jmpAlways {
+			result = fmt.Sprintf("jmp(\"%d\")", arg2)
+		} else {
+			result = fmt.Sprintf("jmp(%s, \"%d\")", pythonJmpConditions[condition], arg2)
+		}
+	case opcodeWAIT:
+		switch waitSource(arg1 & 0x3) {
+		case waitGPIO:
+			result = fmt.Sprintf("wait(%d, gpio, %d)", arg1>>2, arg2)
+		case waitPin:
+			result = fmt.Sprintf("wait(%d, pin, %d)", arg1>>2, arg2)
+		case waitIRQ:
+			switch irqIndexMode(arg2 >> 3 & 0x3) {
+			case irqIndexThis:
+				result = fmt.Sprintf("wait(%d, irq, %d)", arg1>>2, arg2&0x7)
+			case irqIndexRel:
+				result = fmt.Sprintf("wait(%d, irq, rel(%d))", arg1>>2, arg2&0x7)
+			default:
+				return fmt.Sprintf("word(0x%04x)", word)
+			}
+		default:
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+	case opcodeIN:
+		if inSourceNames[arg1] == "" {
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+		result = fmt.Sprintf("in_(%s, %d)", inSourceNames[arg1], bitCount(arg2))
+	case opcodeOUT:
+		result = fmt.Sprintf("out(%s, %d)", outDestinationNames[arg1], bitCount(arg2))
+	case opcodePUSHPULL:
+		if arg2 != 0 {
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+		op, conditional := pushPullNames(arg1)
+		if arg1&0x1 != 0 {
+			result = fmt.Sprintf("%s(%sblock)", op, strings.Replace(conditional, " ", ", ", 1))
+		} else {
+			result = fmt.Sprintf("%s(%snoblock)", op, strings.Replace(conditional, " ", ", ", 1))
+		}
+	case opcodeMOV:
+		if word&0xff == 0x42 {
+			result = "nop()"
+			break
+		}
+		operation := movOperation(arg2 >> 3)
+		source := movSourceNames[arg2&0x7]
+		// `mov pindirs` is PIO version 1
+		if arg1 == 3 || source == "" || operation > movReverse {
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+		switch operation {
+		case movInvert:
+			source = fmt.Sprintf("invert(%s)", source)
+		case movReverse:
+			source = fmt.Sprintf("reverse(%s)", source)
+		}
+		result = fmt.Sprintf("mov(%s, %s)", movDestinationNames[arg1], source)
+	case opcodeIRQ:
+		// `prev` and `next` are PIO version 1
+		if arg1&0x4 != 0 || arg2&0x8 != 0 {
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+		index := fmt.Sprintf("%d", arg2&0x7)
+		if arg2&0x10 != 0 {
+			index = fmt.Sprintf("rel(%s)", index)
+		}
+		switch {
+		case arg1&0x2 != 0:
+			result = fmt.Sprintf("irq(clear, %s)", index)
+		case arg1&0x1 != 0:
+			result = fmt.Sprintf("irq(block, %s)", index)
+		default:
+			result = fmt.Sprintf("irq(%s)", index)
+		}
+	case opcodeSET:
+		if setDestinationNames[arg1] == "" {
+			return fmt.Sprintf("word(0x%04x)", word)
+		}
+		result = fmt.Sprintf("set(%s, %d)", setDestinationNames[arg1], arg2)
+	default:
+		return fmt.Sprintf("word(0x%04x)", word)
+	}
+
+	side, delay := splitDelaySideSet(word, sideSet)
+	if side >= 0 {
+		result += fmt.Sprintf(".side(%d)", side)
+	}
+	if delay > 0 {
+		result += fmt.Sprintf(" [%d]", delay)
+	}
+
+	return result
+}
+
+// jmpTargets returns the offsets targeted by the `jmp` instructions of the program.
+func jmpTargets(words []uint16) map[int]bool {
+	result := make(map[int]bool)
+	for _, word := range words {
+		if word&0xe000 == opcodeJMP {
+			result[int(word&0x1f)] = true
+		}
+	}
+
+	return result
+}
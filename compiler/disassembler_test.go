@@ -0,0 +1,217 @@
+package compiler
+
+import "testing"
+
+func Test_Disassemble(t *testing.T) {
+	t.Run("Disassembles jmp.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0x0003, "jmp    3", "jmp(\"3\")"},
+			{0x0021, "jmp    !x, 1", "jmp(not_x, \"1\")"},
+			{0x00a5, "jmp    x != y, 5", "jmp(x_not_y, \"5\")"},
+			{0x00ff, "jmp    !osre, 31", "jmp(not_osre, \"31\")"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles wait.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0x2085, "wait   1 gpio 5", "wait(1, gpio, 5)"},
+			{0x2023, "wait   0 pin 3", "wait(0, pin, 3)"},
+			{0x20d3, "wait   1 irq 3 rel", "wait(1, irq, rel(3))"},
+			{0x2060, "wait   0 jmppin", "word(0x2060)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles side-set and delay.", func(t *testing.T) {
+		cases := []struct {
+			word    uint16
+			sideSet *AstSideSet
+			source  string
+			python  string
+		}{
+			{0x3f00, &AstSideSet{bits: 1}, "wait   0 gpio 0        side 1 [15]", "wait(0, gpio, 0).side(1) [15]"},
+			{0x3700, &AstSideSet{bits: 1, optional: true}, "wait   0 gpio 0        side 0 [7]", "wait(0, gpio, 0).side(0) [7]"},
+			{0x2700, &AstSideSet{bits: 1, optional: true}, "wait   0 gpio 0        [7]", "wait(0, gpio, 0) [7]"},
+			{0x3f00, &AstSideSet{bits: 5}, "wait   0 gpio 0        side 31", "wait(0, gpio, 0).side(31)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, tc.sideSet); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, tc.sideSet); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles in and out.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0x4001, "in     pins, 1", "in_(pins, 1)"},
+			{0x4060, "in     null, 32", "in_(null, 32)"},
+			{0x4080, "reserved", "word(0x4080)"},
+			{0x60a5, "out    pc, 5", "out(pc, 5)"},
+			{0x60f0, "out    exec, 16", "out(exec, 16)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles push and pull.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0x8020, "push   block", "push(block)"},
+			{0x8040, "push   iffull noblock", "push(iffull, noblock)"},
+			{0x80a0, "pull   block", "pull(block)"},
+			{0x80e0, "pull   ifempty block", "pull(ifempty, block)"},
+			{0x8001, "reserved", "word(0x8001)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles mov and nop.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0xa022, "mov    x, y", "mov(x, y)"},
+			{0xa029, "mov    x, !x", "mov(x, invert(x))"},
+			{0xa0d7, "mov    isr, ::osr", "mov(isr, reverse(osr))"},
+			{0xa042, "nop", "nop()"},
+			{0xa142, "nop                    [1]", "nop() [1]"},
+			{0xa062, "mov    pindirs, y", "word(0xa062)"},
+			{0xa024, "reserved", "word(0xa024)"},
+			{0xa03a, "reserved", "word(0xa03a)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles irq.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0xc003, "irq    nowait 3", "irq(3)"},
+			{0xc021, "irq    wait 1", "irq(block, 1)"},
+			{0xc052, "irq    clear 2 rel", "irq(clear, rel(2))"},
+			{0xc080, "reserved", "word(0xc080)"},
+			{0xc008, "irq    nowait prev 0", "word(0xc008)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles set.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0xe001, "set    pins, 1", "set(pins, 1)"},
+			{0xe03f, "set    x, 31", "set(x, 31)"},
+			{0xe081, "set    pindirs, 1", "set(pindirs, 1)"},
+			{0xe060, "reserved", "word(0xe060)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+
+	t.Run("Disassembles PIO version 1 instructions.", func(t *testing.T) {
+		cases := []struct {
+			word   uint16
+			source string
+			python string
+		}{
+			{0x20e2, "wait   1 jmppin + 2", "word(0x20e2)"},
+			{0x20db, "wait   1 irq next 3", "word(0x20db)"},
+			{0xc03c, "irq    wait next 4", "word(0xc03c)"},
+			{0xa06b, "mov    pindirs, !null", "word(0xa06b)"},
+			{0x8010, "mov    rxfifo[y], isr", "word(0x8010)"},
+			{0x801a, "mov    rxfifo[2], isr", "word(0x801a)"},
+			{0x8090, "mov    osr, rxfifo[y]", "word(0x8090)"},
+			{0x809b, "mov    osr, rxfifo[3]", "word(0x809b)"},
+			{0x8038, "reserved", "word(0x8038)"},
+			{0x8014, "reserved", "word(0x8014)"},
+		}
+
+		for _, tc := range cases {
+			if got := disassemble(tc.word, nil); got != tc.source {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.source)
+			}
+			if got := disassemblePython(tc.word, nil); got != tc.python {
+				t.Errorf("0x%04x: `%s` != `%s`", tc.word, got, tc.python)
+			}
+		}
+	})
+}
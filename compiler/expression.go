@@ -2,12 +2,18 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"math/bits"
 	"strconv"
+	"strings"
 )
 
 type exprParser struct {
 	compiler *compiler
 	line     line
+	last     *lexItem
+	// Operands, evaluated while assembling, may refer to labels declared further in the program
+	operand bool
 }
 
 type operator string
@@ -20,23 +26,96 @@ const (
 	opBinAnd = operator("&")
 	opBinXor = operator("^")
 	opBinOr  = operator("|")
+	opShl    = operator("<<")
+	opShr    = operator(">>")
+
+	opNegate  = operator("-")
+	opReverse = operator("::")
+)
+
+// Binding strength of the expression nodes, from the loosest to the tightest.
+// It mirrors the order of the parseExpr* functions.
+const (
+	precBinOr = iota + 1
+	precBinXor
+	precBinAnd
+	precShift
+	precPlusMinus
+	precMulDiv
+	precUnary
+	precAtom
 )
 
+func (o operator) precedence() int {
+	switch o {
+	case opBinOr:
+		return precBinOr
+	case opBinXor:
+		return precBinXor
+	case opBinAnd:
+		return precBinAnd
+	case opShl, opShr:
+		return precShift
+	case opPlus, opMinus:
+		return precPlusMinus
+	default:
+		return precMulDiv
+	}
+}
+
+// associative tells if `a op (b op c)` may be printed as `a op b op c`.
+func (o operator) associative() bool {
+	switch o {
+	case opPlus, opMul, opBinAnd, opBinXor, opBinOr:
+		return true
+	}
+	return false
+}
+
 type pioInt int32
 
 type AstExpr interface {
 	Ast
 	eval(c *compiler) pioInt
-	inParenthesis() bool
+	precedence() int
+	// start returns the first token of the expression, used to point at it in errors
+	start() *lexItem
+}
+
+// parenthesize renders the expression, wrapped in parens if it binds looser than `precedence`.
+func parenthesize(expr AstExpr, precedence int) string {
+	if expr.precedence() < precedence {
+		return fmt.Sprintf("(%s)", expr.ToSource())
+	}
+	return expr.ToSource()
+}
+
+// wraps tells if a result computed on 64 bits doesn't fit 32 bits, neither signed nor unsigned.
+func wraps(value int64) bool {
+	return value < math.MinInt32 || value > math.MaxUint32
 }
 
 type AstValue struct {
-	inParenthesisVal bool
-	value            pioInt
+	token *lexItem
+	value pioInt
+}
+
+func (a *AstValue) precedence() int {
+	// Negative values are printed with a leading minus so read back as unary operations
+	if a.value < 0 && !a.literal() {
+		return precUnary
+	}
+	return precAtom
 }
 
-func (a *AstValue) inParenthesis() bool {
-	return a.inParenthesisVal
+// literal tells if the value is a number of the source. It is never negative, the ones above 0x7fffffff
+// wrap around in pioInt and are printed unsigned.
+func (a *AstValue) literal() bool {
+	return a.token != nil && a.token.typ == itemNumber
+}
+
+func (a *AstValue) start() *lexItem {
+	return a.token
 }
 
 func (a *AstValue) eval(*compiler) pioInt {
@@ -44,28 +123,41 @@ func (a *AstValue) eval(*compiler) pioInt {
 }
 
 func (a *AstValue) ToSource() string {
+	if a.literal() {
+		return fmt.Sprintf("%d", uint32(a.value))
+	}
+
 	return fmt.Sprintf("%d", a.value)
 }
 
 type AstBinOp struct {
-	inParenthesisVal bool
-	name             operator
-	left             AstExpr
-	right            AstExpr
+	token *lexItem
+	name  operator
+	left  AstExpr
+	right AstExpr
+}
+
+func (a *AstBinOp) precedence() int {
+	return a.name.precedence()
 }
 
-func (a *AstBinOp) inParenthesis() bool {
-	return a.inParenthesisVal
+func (a *AstBinOp) start() *lexItem {
+	return a.left.start()
 }
 
 func (a *AstBinOp) ToSource() string {
-	left := a.left.ToSource()
-	right := a.right.ToSource()
-	result := fmt.Sprintf("%s %s %s", left, a.name, right)
-	if a.inParenthesis() {
-		result = fmt.Sprintf("(%s)", result)
+	precedence := a.precedence()
+	left := parenthesize(a.left, precedence)
+	// Operators are left-associative so the right operand of the same strength needs parens,
+	// unless it is the same associative operator applied to a tighter bound left operand.
+	rightPrecedence := precedence + 1
+	if right, ok := a.right.(*AstBinOp); ok && right.name == a.name && a.name.associative() &&
+		right.left.precedence() > precedence {
+		rightPrecedence = precedence
 	}
-	return result
+	right := parenthesize(a.right, rightPrecedence)
+
+	return fmt.Sprintf("%s %s %s", left, a.name, right)
 }
 
 func (a *AstBinOp) eval(c *compiler) pioInt {
@@ -73,12 +165,21 @@ func (a *AstBinOp) eval(c *compiler) pioInt {
 	right := a.right.eval(c)
 	switch a.name {
 	case opPlus:
+		c.checkOverflow(int64(left)+int64(right), a.token)
 		return left + right
 	case opMinus:
+		c.checkOverflow(int64(left)-int64(right), a.token)
 		return left - right
 	case opMul:
+		c.checkOverflow(int64(left)*int64(right), a.token)
 		return left * right
 	case opDiv:
+		if right == 0 {
+			c.raiseError("Division by zero", a.token)
+		}
+		if left == math.MinInt32 && right == -1 {
+			c.raiseError("Signed overflow in division", a.token)
+		}
 		return left / right
 	case opBinOr:
 		return left | right
@@ -86,18 +187,79 @@ func (a *AstBinOp) eval(c *compiler) pioInt {
 		return left ^ right
 	case opBinAnd:
 		return left & right
+	case opShl:
+		if right < 0 {
+			c.raiseError("Negative shift count", a.token)
+		}
+		if right >= 32 {
+			c.checkWraparound(int64(left)<<32, a.token)
+		} else {
+			c.checkWraparound(int64(left)<<right, a.token)
+		}
+		// Counts of 32 and more shift every bit out, as a 32-bit register would
+		return left << uint32(right)
+	case opShr:
+		if right < 0 {
+			c.raiseError("Negative shift count", a.token)
+		}
+		return left >> uint32(right)
+	default:
+		c.raiseError(fmt.Sprintf("Unknown operator `%s`", a.name), a.token)
+		return 0
+	}
+}
+
+type AstUnaryOp struct {
+	token *lexItem
+	name  operator
+	expr  AstExpr
+}
+
+func (a *AstUnaryOp) precedence() int {
+	return precUnary
+}
+
+func (a *AstUnaryOp) start() *lexItem {
+	return a.token
+}
+
+func (a *AstUnaryOp) ToSource() string {
+	operand := parenthesize(a.expr, precUnary)
+	// A negative operand of a minus is kept in parentheses, `--1` is not what was written
+	if a.name == opNegate && strings.HasPrefix(operand, string(opNegate)) {
+		operand = fmt.Sprintf("(%s)", operand)
+	}
+
+	return fmt.Sprintf("%s%s", a.name, operand)
+}
+
+func (a *AstUnaryOp) eval(c *compiler) pioInt {
+	value := a.expr.eval(c)
+	switch a.name {
+	case opNegate:
+		c.checkOverflow(-int64(value), a.token)
+		return -value
+	case opReverse:
+		return pioInt(bits.Reverse32(uint32(value)))
 	default:
-		panic(fmt.Sprintf("Unknown operation `%s`", a.name))
+		c.raiseError(fmt.Sprintf("Unknown operator `%s`", a.name), a.token)
+		return 0
 	}
 }
 
 type AstIdentifier struct {
-	inParenthesisVal bool
-	name             string
+	token *lexItem
+	name  string
+	// Scope the identifier is resolved in, nil for the file level
+	program *AstProgram
 }
 
-func (a *AstIdentifier) inParenthesis() bool {
-	return a.inParenthesisVal
+func (a *AstIdentifier) precedence() int {
+	return precAtom
+}
+
+func (a *AstIdentifier) start() *lexItem {
+	return a.token
 }
 
 func (a *AstIdentifier) ToSource() string {
@@ -105,51 +267,75 @@ func (a *AstIdentifier) ToSource() string {
 }
 
 func (a *AstIdentifier) eval(c *compiler) pioInt {
-	return c.getValueByIdentifier(a.name)
+	return c.getValueByIdentifier(a)
 }
 
+// parseExpr parses the expression taking the rest of the line.
 func (c *compiler) parseExpr(l line) AstExpr {
 	ep := exprParser{line: l, compiler: c}
-	return ep.parseExprBinOr(false)
+	result := ep.parseExprBinOr()
+	if len(ep.line) > 0 && ep.line[0].typ != itemEOF {
+		c.raiseError("Unexpected item", ep.line[0])
+	}
+
+	return result
 }
 
-func (ep *exprParser) parseExprBinOr(inParent bool) AstExpr {
-	left := ep.parseExprBinXor(inParent)
+func (ep *exprParser) parseExprBinOr() AstExpr {
+	left := ep.parseExprBinXor()
 
 	for len(ep.line) > 0 && ep.line[0].typ == itemBinOr {
-		ep.next()
-		right := ep.parseExprBinXor(inParent)
-		left = &AstBinOp{name: opBinOr, left: left, right: right, inParenthesisVal: inParent}
+		lexItem := ep.next()
+		right := ep.parseExprBinXor()
+		left = &AstBinOp{token: lexItem, name: opBinOr, left: left, right: right}
 	}
 
 	return left
 }
 
-func (ep *exprParser) parseExprBinXor(inParent bool) AstExpr {
-	left := ep.parseExprBinAnd(inParent)
+func (ep *exprParser) parseExprBinXor() AstExpr {
+	left := ep.parseExprBinAnd()
 
 	for len(ep.line) > 0 && ep.line[0].typ == itemBinXor {
-		ep.next()
-		right := ep.parseExprBinAnd(inParent)
-		left = &AstBinOp{name: opBinXor, left: left, right: right, inParenthesisVal: inParent}
+		lexItem := ep.next()
+		right := ep.parseExprBinAnd()
+		left = &AstBinOp{token: lexItem, name: opBinXor, left: left, right: right}
 	}
 
 	return left
 }
 
-func (ep *exprParser) parseExprBinAnd(inParent bool) AstExpr {
-	left := ep.parseExprPlusMinus(inParent)
+func (ep *exprParser) parseExprBinAnd() AstExpr {
+	left := ep.parseExprShift()
 
 	for len(ep.line) > 0 && ep.line[0].typ == itemBinAnd {
-		ep.next()
-		right := ep.parseExprPlusMinus(inParent)
-		left = &AstBinOp{name: opBinAnd, left: left, right: right, inParenthesisVal: inParent}
+		lexItem := ep.next()
+		right := ep.parseExprShift()
+		left = &AstBinOp{token: lexItem, name: opBinAnd, left: left, right: right}
 	}
 
 	return left
 }
 
-func (ep *exprParser) parseExprPlusMinus(inParen bool) AstExpr {
+func (ep *exprParser) parseExprShift() AstExpr {
+	left := ep.parseExprPlusMinus()
+
+	for len(ep.line) > 0 && (ep.line[0].typ == itemShiftLeft || ep.line[0].typ == itemShiftRight) {
+		lexItem := ep.next()
+		var name operator
+		if lexItem.typ == itemShiftLeft {
+			name = opShl
+		} else {
+			name = opShr
+		}
+		right := ep.parseExprPlusMinus()
+		left = &AstBinOp{token: lexItem, name: name, left: left, right: right}
+	}
+
+	return left
+}
+
+func (ep *exprParser) parseExprPlusMinus() AstExpr {
 	left := ep.parseExprMulDiv()
 
 	for len(ep.line) > 0 && (ep.line[0].typ == itemPlus || ep.line[0].typ == itemMinus) {
@@ -161,14 +347,14 @@ func (ep *exprParser) parseExprPlusMinus(inParen bool) AstExpr {
 			name = opMinus
 		}
 		right := ep.parseExprMulDiv()
-		left = &AstBinOp{name: name, left: left, right: right, inParenthesisVal: inParen}
+		left = &AstBinOp{token: lexItem, name: name, left: left, right: right}
 	}
 
 	return left
 }
 
 func (ep *exprParser) parseExprMulDiv() AstExpr {
-	left := ep.parseExprSymbolsConsParens()
+	left := ep.parseExprUnary()
 
 	for len(ep.line) > 0 && (ep.line[0].typ == itemStar || ep.line[0].typ == itemSlash) {
 		lexItem := ep.next()
@@ -178,39 +364,66 @@ func (ep *exprParser) parseExprMulDiv() AstExpr {
 		} else {
 			name = opDiv
 		}
-		right := ep.parseExprSymbolsConsParens()
-		left = &AstBinOp{name: name, left: left, right: right}
+		right := ep.parseExprUnary()
+		left = &AstBinOp{token: lexItem, name: name, left: left, right: right}
 	}
 
 	return left
 }
 
-// TODO OR, AND, XOR
-// TODO MINUS _
-// TODO REVERSE _
+func (ep *exprParser) parseExprUnary() AstExpr {
+	if len(ep.line) > 0 && (ep.line[0].typ == itemMinus || ep.line[0].typ == itemReverse) {
+		lexItem := ep.next()
+		var name operator
+		if lexItem.typ == itemMinus {
+			name = opNegate
+		} else {
+			name = opReverse
+		}
+		expr := ep.parseExprUnary()
+		return &AstUnaryOp{token: lexItem, name: name, expr: expr}
+	}
+
+	return ep.parseExprSymbolsConsParens()
+}
 
 func (ep *exprParser) parseExprSymbolsConsParens() AstExpr {
+	if len(ep.line) == 0 {
+		ep.compiler.raiseError("Unexpected end of expression", ep.last)
+	}
+	// The lexer stops at a malformed number, e.g. `0x` or `0b102`
+	if ep.line[0].typ == itemError && ep.line[0].val != "" && isValue([]rune(ep.line[0].val)[0]) {
+		ep.compiler.raiseError("Invalid number literal", ep.line[0])
+	}
 	if ep.line[0].typ == itemNumber {
 		lexItem := ep.next()
-		value, _ := strconv.ParseInt(lexItem.val, 0, 0)
-		return &AstValue{value: pioInt(value)}
+		value, err := strconv.ParseInt(lexItem.val, 0, 64)
+		if err != nil || value > math.MaxUint32 {
+			ep.compiler.raiseError("Number literal out of range", lexItem)
+		}
+		return &AstValue{token: lexItem, value: pioInt(value)}
 	} else if ep.line[0].typ == itemSymbol {
 		lexItem := ep.next()
-		if ep.compiler.getDefineDeclared(lexItem.val) == nil {
+		// Forward references are checked once all the defines are known
+		if !ep.operand && !ep.compiler.options.ForwardRefs && ep.compiler.getDefineDeclared(lexItem.val) == nil {
 			ep.compiler.raiseError("Unknown identifier in expression", lexItem)
 		}
-		return &AstIdentifier{name: lexItem.val}
+		return &AstIdentifier{token: lexItem, name: lexItem.val, program: ep.compiler.currentProgram}
 	}
 	ep.expect(itemLParent)
-	result := ep.parseExprBinOr(true)
+	result := ep.parseExprBinOr()
 	ep.expect(itemRParent)
 
 	return result
 }
 
 func (ep *exprParser) next() *lexItem {
+	if len(ep.line) == 0 {
+		ep.compiler.raiseError("Unexpected end of expression", ep.last)
+	}
 	result := ep.line[0]
 	ep.line = ep.line[1:]
+	ep.last = result
 
 	return result
 }
@@ -221,3 +434,18 @@ func (ep *exprParser) expect(itemType itemType) {
 		ep.compiler.raiseError("Syntax error in expression", lexItem)
 	}
 }
+
+func (c *compiler) checkWraparound(value int64, item *lexItem) {
+	if c.options.WarnWraparound && wraps(value) {
+		c.raiseWarning("Expression value wraps around 32 bits", item)
+	}
+}
+
+// checkOverflow warns about the arithmetic results which wrap around 32 bits or overflow the signed ones,
+// e.g. `0x7fffffff + 1`. Shifts into the sign bit are left to checkWraparound as they build masks.
+func (c *compiler) checkOverflow(value int64, item *lexItem) {
+	c.checkWraparound(value, item)
+	if c.options.WarnWraparound && !wraps(value) && value > math.MaxInt32 {
+		c.raiseWarning("Signed overflow", item)
+	}
+}
@@ -1,6 +1,10 @@
 package compiler
 
-import "testing"
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
 
 func Test_Compile_Expr(t *testing.T) {
 	t.Run("Parses expression. Case 1.", func(t *testing.T) {
@@ -67,8 +71,7 @@ func Test_Compile_Expr(t *testing.T) {
 
 		sourceOut := ast.ToSource()
 
-		// NOTE Removing of not needed parens
-		if sourceOut != `.define A (1 + 2) / (3 - 5) * 4 / 2 ; = -2
+		if sourceOut != `.define A (1 + 2) / (3 - 5) * (4 / 2) ; = -2
 ` {
 			t.Logf(sourceOut)
 			t.Errorf("Regenerated source is different")
@@ -88,8 +91,7 @@ func Test_Compile_Expr(t *testing.T) {
 
 		sourceOut := ast.ToSource()
 
-		// NOTE Removing of not needed parens
-		if sourceOut != `.define A (1 + 2) / (3 - 5) * 4 / 2 ; = -2
+		if sourceOut != `.define A (1 + 2) / (3 - 5) * (4 / 2) ; = -2
 ` {
 			t.Logf(sourceOut)
 			t.Errorf("Regenerated source is different")
@@ -215,4 +217,406 @@ func Test_Compile_Expr(t *testing.T) {
 			t.Errorf("%#v", e)
 		}
 	})
+
+	t.Run("Parses unary minus. Case 1.", func(t *testing.T) {
+		source := `
+.define A -1
+.define B 3 - -A
+.define C -(2 + 3) * 2
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A -1 ; = -1
+.define B 3 - -A ; = 2
+.define C -(2 + 3) * 2 ; = -10
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Parses bit-reverse. Case 1.", func(t *testing.T) {
+		source := `
+.define A ::0b1011
+.define B ::A
+.define C ::1 | 1
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A ::11 ; = -805306368
+.define B ::A ; = 11
+.define C ::1 | 1 ; = -2147483647
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Parses expression with shift operators. Case 1.", func(t *testing.T) {
+		source := `
+.define PIN_BASE 3
+.define MASK 1 << PIN_BASE
+.define MASK_2 3 << PIN_BASE + 1 & 0xff
+.define HIGH -16 >> 2
+.define GONE 1 << 32
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define PIN_BASE 3
+.define MASK 1 << PIN_BASE ; = 8
+.define MASK_2 3 << PIN_BASE + 1 & 255 ; = 48
+.define HIGH -16 >> 2 ; = -4
+.define GONE 1 << 32 ; = 0
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Parses expression with parens. Case 4.", func(t *testing.T) {
+		source := `
+.define A 8 - (4 - 2) - (1 << 2 << 1)
+.define B -(1 + 2) * ::(4 | 1) ^ (1 & 3)
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A 8 - (4 - 2) - (1 << 2 << 1) ; = -2
+.define B -(1 + 2) * ::(4 | 1) ^ 1 & 3 ; = 536870913
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Parses expression with parens. Case 5.", func(t *testing.T) {
+		source := `
+.define A ((1 + 2)) * (3 * 4) - (5) + (1 + 2 * 3)
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		// NOTE Removing of not needed parens
+		if sourceOut != `.define A (1 + 2) * 3 * 4 - 5 + 1 + 2 * 3 ; = 38
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Parses nested unary minus.", func(t *testing.T) {
+		source := `
+.define A -(-1)
+.define B -(-(-2))
+.define C -(-2147483648)
+.define D 1 - -1
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.define A -(-1) ; = 1
+.define B -(-(-2)) ; = -2
+.define C -(-2147483648) ; = -2147483648
+.define D 1 - -1 ; = 2
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+
+		reparsed, e := Compile(sourceOut, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+		if reparsed.ToSource() != sourceOut {
+			t.Logf(reparsed.ToSource())
+			t.Errorf("Source doesn't round-trip")
+		}
+	})
+
+	t.Run("Round-trips random expressions through ToSource.", func(t *testing.T) {
+		r := rand.New(rand.NewSource(1))
+		c := &compiler{options: &Options{}}
+
+		for i := 0; i < 1000; i++ {
+			expr := randomExpr(r, c, 4)
+			source := fmt.Sprintf(".define A %s\n", expr.ToSource())
+
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Fatalf("%s: %#v", source, e)
+			}
+			if got, want := ast.defines[0].value, expr.eval(c); got != want {
+				t.Fatalf("%s: %d != %d", source, got, want)
+			}
+		}
+	})
+
+	t.Run("Error if division by zero.", func(t *testing.T) {
+		source := `
+.define A 0
+.define B 1 / A
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Division by zero" || e.line != 3 || e.offset != 13 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if signed overflow in division.", func(t *testing.T) {
+		source := `.define A 0x80000000 / -1`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Signed overflow in division" || e.line != 1 || e.offset != 22 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if negative shift count.", func(t *testing.T) {
+		source := `.define A 1 << -1`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Negative shift count" {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if expression is incomplete.", func(t *testing.T) {
+		source := `
+.define A 1 +
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Unexpected end of expression" || e.line != 2 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if number literal has invalid digit.", func(t *testing.T) {
+		for _, source := range []string{".define A 0b102", ".define A 0x1g"} {
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%#v", ast)
+			}
+			if e == nil || e.message != "Invalid number literal" || e.line != 1 || e.offset != 11 {
+				t.Errorf("%s: %#v", source, e)
+			}
+		}
+	})
+
+	t.Run("Error if number literal is out of range.", func(t *testing.T) {
+		source := `.define A 99999999999999999999`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Number literal out of range" || e.line != 1 || e.offset != 11 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if number literal is wider than 32 bits.", func(t *testing.T) {
+		source := `.define A 0x100000000`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Number literal out of range" || e.line != 1 || e.offset != 11 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Accepts the widest number literal.", func(t *testing.T) {
+		source := `.define public A 0xffffffff`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+		if ast.ToSource() != ".define public A 4294967295\n" {
+			t.Errorf("%q", ast.ToSource())
+		}
+	})
+
+	t.Run("Error if expression is followed by another item.", func(t *testing.T) {
+		source := `.define A 1 2`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Unexpected item" || e.line != 1 || e.offset != 13 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if operand is followed by another item.", func(t *testing.T) {
+		source := `
+.program test
+set x, 1 2
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Unexpected item" || e.line != 3 || e.offset != 10 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Warns about wraparound if requested.", func(t *testing.T) {
+		source := `
+.define A 0x7fffffff + 1
+.define B 0x80000000 * 2
+.define C 1 << 31
+.define D 3 << 31
+`
+
+		ast, e := Compile(source, &Options{WarnWraparound: true})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+		if len(ast.warnings) != 3 {
+			t.Fatalf("%#v", ast.warnings)
+		}
+		if w := ast.warnings[0]; w.message != "Signed overflow" || w.line != 2 || w.offset != 22 {
+			t.Errorf("%#v", w)
+		}
+		if w := ast.warnings[1]; w.message != "Expression value wraps around 32 bits" || w.line != 3 || w.offset != 22 {
+			t.Errorf("%#v", w)
+		}
+		if w := ast.warnings[2]; w.message != "Expression value wraps around 32 bits" || w.line != 5 || w.offset != 13 {
+			t.Errorf("%#v", w)
+		}
+	})
+
+	t.Run("Warns about signed overflow of negation and subtraction if requested.", func(t *testing.T) {
+		source := `
+.define A 0x7fffffff - -1
+.define B -(-0x7fffffff - 1)
+.define C 0x40000000 * 2
+`
+
+		ast, e := Compile(source, &Options{WarnWraparound: true})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+		if len(ast.warnings) != 3 {
+			t.Fatalf("%#v", ast.warnings)
+		}
+		for i, w := range ast.warnings {
+			if w.message != "Signed overflow" || w.line != i+2 {
+				t.Errorf("%#v", w)
+			}
+		}
+	})
+
+	t.Run("Doesn't warn about wraparound by default.", func(t *testing.T) {
+		source := `.define A 0x80000000 * 2`
+
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Errorf("%#v", e)
+		}
+		if len(ast.warnings) != 0 {
+			t.Errorf("%#v", ast.warnings)
+		}
+	})
+}
+
+var randomOperators = []operator{opPlus, opMinus, opMul, opDiv, opBinAnd, opBinXor, opBinOr, opShl, opShr}
+
+func randomExpr(r *rand.Rand, c *compiler, depth int) AstExpr {
+	switch n := r.Intn(10); {
+	case depth == 0 || n < 3:
+		return &AstValue{value: pioInt(r.Int31n(1000) - 500)}
+	case n < 4:
+		return &AstUnaryOp{name: opNegate, expr: randomExpr(r, c, depth-1)}
+	case n < 5:
+		return &AstUnaryOp{name: opReverse, expr: randomExpr(r, c, depth-1)}
+	default:
+		name := randomOperators[r.Intn(len(randomOperators))]
+		var right AstExpr
+		switch name {
+		case opShl, opShr:
+			right = &AstValue{value: pioInt(r.Int31n(40))}
+		case opDiv:
+			// Avoid division errors
+			for right == nil || right.eval(c) == 0 || right.eval(c) == -1 {
+				right = randomExpr(r, c, depth-1)
+			}
+		default:
+			right = randomExpr(r, c, depth-1)
+		}
+		return &AstBinOp{name: name, left: randomExpr(r, c, depth-1), right: right}
+	}
 }
@@ -0,0 +1,140 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AstInclude is `.include`. The items of the included file follow it in place but ToSource keeps the directive
+// instead of them.
+type AstInclude struct {
+	token *lexItem
+	name  string
+	// path is the included file found in the directory of the including file or in the include paths
+	path string
+	// index is the number of instructions of the program before the directive
+	index int
+	// including is the lexer of the file with the directive, parsed again once the included file ends
+	including *lexer
+	// program is the one being parsed at the directive and continued the one opened by the included file,
+	// which the items after the directive belong to, or nil
+	program   *AstProgram
+	continued *AstProgram
+}
+
+func (a *AstInclude) ToSource() string {
+	return fmt.Sprintf(".include %q", a.name)
+}
+
+// parseInclude parses `.include "<file>"` and switches the lexer to the included file.
+func (c *compiler) parseInclude(l line) *AstInclude {
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	message := "Expected the file name in double quotes"
+	if ip.peek() == itemEOL {
+		c.raiseError(message, ip.last)
+	}
+	name := ip.next()
+	if name.typ != itemString {
+		c.raiseError(message, name)
+	}
+	ip.end()
+
+	ast := &AstInclude{token: l[0], name: strings.Trim(name.val, `"`), program: c.currentProgram}
+	if c.currentProgram != nil {
+		ast.index = len(c.currentProgram.instructions)
+	}
+	if ast.name == "" {
+		c.raiseError(message, name)
+	}
+	ast.path = c.resolveInclude(ast.name, name)
+	c.checkIncludeCycle(ast.path, name)
+
+	source, err := os.ReadFile(ast.path)
+	if err != nil {
+		c.raiseError(fmt.Sprintf("Cannot read the included file `%s`", ast.name), name)
+	}
+	ast.including = c.lex
+	c.includes = append(c.includes, ast)
+	c.lex, _ = lex(ast.path, string(source))
+	c.lex.included = true
+
+	return ast
+}
+
+// endInclude continues parsing the including file once the included one ends.
+func (c *compiler) endInclude() {
+	ast := c.includes[len(c.includes)-1]
+	c.includes = c.includes[:len(c.includes)-1]
+	c.lex = ast.including
+	if c.currentProgram != ast.program {
+		ast.continued = c.currentProgram
+	}
+}
+
+// writeSource writes the directive and the items of the including file which belong to the program opened by
+// the included one.
+func (a *AstInclude) writeSource(b *bytes.Buffer) {
+	b.WriteString(a.ToSource() + "\n")
+	if a.continued != nil {
+		b.WriteString(a.continued.ToSource())
+	}
+}
+
+// included tells if the item comes from a file read by `.include`. ToSource skips these items.
+func included(item *lexItem) bool {
+	return item != nil && item.lex != nil && item.lex.included
+}
+
+// resolveInclude returns the path of the file, looked up next to the including file and then in the include paths.
+func (c *compiler) resolveInclude(name string, item *lexItem) string {
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(filepath.Dir(item.lex.name), name)}
+		for _, dir := range c.options.IncludePaths {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	c.raiseError(fmt.Sprintf("Cannot find the included file `%s`", name), item)
+
+	return ""
+}
+
+// checkIncludeCycle raises an error if the file is being parsed already, i.e. it includes itself.
+func (c *compiler) checkIncludeCycle(path string, item *lexItem) {
+	lexers := make([]*lexer, 0, len(c.includes)+1)
+	for _, include := range c.includes {
+		lexers = append(lexers, include.including)
+	}
+	lexers = append(lexers, c.lex)
+	for i, lexer := range lexers {
+		if lexer.name == "" || !sameFile(lexer.name, path) {
+			continue
+		}
+		names := make([]string, 0)
+		for _, including := range lexers[i:] {
+			names = append(names, including.name)
+		}
+		names = append(names, path)
+		c.raiseError("Cyclic include: "+strings.Join(names, " -> "), item)
+	}
+}
+
+// sameFile tells if the paths name the same file.
+func sameFile(a string, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,162 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Include(t *testing.T) {
+	dir := t.TempDir()
+	write := func(t *testing.T, name string, source string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	t.Run("Parses the included files in place.", func(t *testing.T) {
+		write(t, "pins.pio", ".define public LED 25\n.define BAUD 115200")
+		write(t, "lib/blink.pio", "\n.program blink\n\tset pins, 1\n")
+		main := write(t, "main.pio", `.include "pins.pio"
+.include "blink.pio"
+	set pins, 0
+.program uart
+	set x, LED
+`)
+
+		ast, e := CompileFile(main, &Options{IncludePaths: []string{filepath.Join(dir, "lib")}})
+
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+		if len(ast.defines) != 2 || ast.defines[1].name != "BAUD" {
+			t.Errorf("%#v", ast.defines)
+		}
+		blink := ast.Program("blink")
+		if blink == nil || len(blink.assembler) != 2 || blink.assembler[1] != 0xe000 {
+			t.Fatalf("%#v", blink)
+		}
+		if blink.SourceLine(0) != 3 || blink.SourceLine(1) != 3 {
+			t.Errorf("%v", blink.sourceLines)
+		}
+		if blink.SourceFile(0) != filepath.Join(dir, "lib/blink.pio") || blink.SourceFile(1) != main {
+			t.Errorf("%v", blink.sourceFiles)
+		}
+		if uart := ast.Program("uart"); uart == nil || uart.assembler[0] != 0xe039 {
+			t.Errorf("%#v", uart)
+		}
+	})
+
+	t.Run("Keeps the directive instead of the included items in the source.", func(t *testing.T) {
+		write(t, "source/body.pio", "\tset x, 1\n.define N 2\nbody:\n")
+		write(t, "source/blink.pio", ".program blink\n\tset pins, 1\n")
+		source := `.include "blink.pio"
+	set pins, 0
+.program test
+	nop
+.include "body.pio"
+	jmp body
+`
+		main := write(t, "source/main.pio", source)
+
+		ast, e := CompileFile(main, &Options{})
+
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+		if test := ast.Program("test"); len(test.assembler) != 3 || test.assembler[2] != 0x0002 {
+			t.Errorf("%#v", test.assembler)
+		}
+		if sourceOut := ast.ToSource(); sourceOut != source {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Looks up the included files from the include paths.", func(t *testing.T) {
+		write(t, "lib/common.pio", ".define N 3\n")
+
+		ast, e := Compile(".include \"common.pio\"\n.program test\n\tset x, N\n",
+			&Options{IncludePaths: []string{filepath.Join(dir, "missing"), filepath.Join(dir, "lib")}})
+
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+		if ast.programs[0].assembler[0] != 0xe023 {
+			t.Errorf("0x%04x", ast.programs[0].assembler[0])
+		}
+	})
+
+	t.Run("Errors name the file.", func(t *testing.T) {
+		included := write(t, "error/included.pio", ".program test\n\tnop\n\tset x, 32\n")
+		main := write(t, "error/main.pio", ".include \"included.pio\"\n\tset y, 32\n")
+
+		_, e := CompileFile(main, &Options{})
+
+		if e == nil || e.file != included || e.line != 3 || e.offset != 9 {
+			t.Errorf("%#v", e)
+		}
+		if e.ToString() != "Set value must be in 0..31: "+included+":3:9" {
+			t.Errorf("%s", e.ToString())
+		}
+
+		write(t, "error/included.pio", ".program test\n\tnop")
+
+		_, e = CompileFile(main, &Options{})
+
+		if e == nil || e.file != main || e.line != 2 || e.offset != 9 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if the include is invalid.", func(t *testing.T) {
+		a := write(t, "cycle/a.pio", ".include \"b.pio\"\n")
+		b := write(t, "cycle/b.pio", ".define B 1\n.include \"a.pio\"\n")
+		cases := []struct {
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{".include\n", "Expected the file name in double quotes", 1, 1},
+			{".include common.pio\n", "Expected the file name in double quotes", 1, 10},
+			{".include \"\"\n", "Expected the file name in double quotes", 1, 10},
+			{".include \"common.pio\" 1\n", "Unexpected item", 1, 23},
+			{".include \"missing.pio\"\n", "Cannot find the included file `missing.pio`", 1, 10},
+			{".include \"common.pio\n", "Expected the file name in double quotes", 1, 10},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+
+		_, e := CompileFile(a, &Options{})
+
+		if e == nil || e.message != "Cyclic include: "+a+" -> "+b+" -> "+a || e.file != b || e.line != 2 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if the file is missing.", func(t *testing.T) {
+		path := filepath.Join(dir, "missing.pio")
+
+		_, e := CompileFile(path, &Options{})
+
+		if e == nil || e.ToString() != "Cannot read the file: "+path {
+			t.Errorf("%#v", e)
+		}
+	})
+}
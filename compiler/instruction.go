@@ -0,0 +1,982 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	opcodeJMP uint16 = iota << 13
+	opcodeWAIT
+	opcodeIN
+	opcodeOUT
+	opcodePUSHPULL
+	opcodeMOV
+	opcodeIRQ
+	opcodeSET
+)
+
+// astOperation is the instruction without its side-set and delay.
+type astOperation interface {
+	Ast
+	// encode returns the instruction word with the delay/side-set bits cleared
+	encode(c *compiler) uint16
+}
+
+type AstSideSet struct {
+	token    *lexItem
+	count    AstExpr
+	optional bool
+	pindirs  bool
+	// Evaluated while assembling
+	bits int
+}
+
+func (a *AstSideSet) ToSource() string {
+	result := fmt.Sprintf(".side_set %s", a.count.ToSource())
+	if a.optional {
+		result += " opt"
+	}
+	if a.pindirs {
+		result += " pindirs"
+	}
+
+	return result
+}
+
+// bitsIncludingOpt is the width of the side-set part of the delay/side-set field.
+func (a *AstSideSet) bitsIncludingOpt() int {
+	if a == nil {
+		return 0
+	}
+	if a.optional {
+		return a.bits + 1
+	}
+
+	return a.bits
+}
+
+type AstInstruction struct {
+	token     *lexItem
+	operation astOperation
+	sideSet   AstExpr
+	delay     AstExpr
+}
+
+func (a *AstInstruction) ToSource() string {
+	result := a.operation.ToSource()
+	if a.sideSet != nil {
+		result += fmt.Sprintf(" side %s", a.sideSet.ToSource())
+	}
+	if a.delay != nil {
+		result += fmt.Sprintf(" [%s]", a.delay.ToSource())
+	}
+
+	return result
+}
+
+type instrParser struct {
+	compiler *compiler
+	line     line
+	last     *lexItem
+}
+
+// peek returns the type of the next item; the end of the line is reported as itemEOL.
+func (ip *instrParser) peek() itemType {
+	if len(ip.line) == 0 || ip.line[0].typ == itemEOF {
+		return itemEOL
+	}
+
+	return ip.line[0].typ
+}
+
+func (ip *instrParser) next() *lexItem {
+	if ip.peek() == itemEOL {
+		ip.compiler.raiseError("Unexpected end of line", ip.last)
+	}
+	result := ip.line[0]
+	ip.line = ip.line[1:]
+	ip.last = result
+
+	return result
+}
+
+// accept consumes the next item if it is of the type.
+func (ip *instrParser) accept(itemType itemType) *lexItem {
+	if ip.peek() == itemType {
+		return ip.next()
+	}
+
+	return nil
+}
+
+func (ip *instrParser) expect(itemType itemType, message string) *lexItem {
+	if ip.peek() == itemEOL {
+		ip.compiler.raiseError(message, ip.last)
+	}
+	lexItem := ip.next()
+	if lexItem.typ != itemType {
+		ip.compiler.raiseError(message, lexItem)
+	}
+
+	return lexItem
+}
+
+func (ip *instrParser) expr() AstExpr {
+	ep := exprParser{compiler: ip.compiler, line: ip.line, last: ip.last, operand: true}
+	result := ep.parseExprBinOr()
+	ip.line = ep.line
+	ip.last = ep.last
+
+	return result
+}
+
+// operand consumes an operand keyword and returns its type if it is one of `codes`.
+func (ip *instrParser) operand(codes map[itemType]uint16, message string) itemType {
+	if ip.peek() == itemEOL {
+		ip.compiler.raiseError(message, ip.last)
+	}
+	lexItem := ip.next()
+	if _, ok := codes[lexItem.typ]; !ok {
+		ip.compiler.raiseError(message, lexItem)
+	}
+
+	return lexItem.typ
+}
+
+// comma consumes the optional comma between operands.
+func (ip *instrParser) comma() {
+	ip.accept(itemComma)
+}
+
+func (ip *instrParser) end() {
+	if ip.peek() != itemEOL {
+		ip.compiler.raiseError("Unexpected item", ip.line[0])
+	}
+}
+
+func (c *compiler) parseSideSet(l line) *AstSideSet {
+	if c.currentProgram == nil {
+		c.raiseError("`.side_set` outside of a program", l[0])
+	}
+	if c.currentProgram.sideSet != nil {
+		c.raiseError("Side-set already defined", l[0])
+	}
+	if len(c.currentProgram.instructions) > 0 {
+		c.raiseError("`.side_set` must precede the instructions", l[0])
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstSideSet{token: l[0], count: ip.expr()}
+	if ip.accept(itemOptional) != nil {
+		ast.optional = true
+	}
+	if ip.accept(itemPindirs) != nil {
+		ast.pindirs = true
+	}
+	ip.end()
+
+	return ast
+}
+
+// AstPioVersion selects the instruction set: 0 for RP2040 and 1 for RP2350.
+type AstPioVersion struct {
+	token   *lexItem
+	version AstExpr
+	// name is `RP2040` or `RP2350` if the version is given by the name of the chip
+	name string
+}
+
+func (a *AstPioVersion) ToSource() string {
+	if a.name != "" {
+		return ".pio_version " + a.name
+	}
+
+	return ".pio_version " + a.version.ToSource()
+}
+
+// pioVersionNames are the chip names accepted by `.pio_version`.
+var pioVersionNames = map[string]pioInt{
+	"RP2040": 0,
+	"RP2350": 1,
+}
+
+func (c *compiler) parsePioVersion(l line) *AstPioVersion {
+	if program := c.currentProgram; program != nil {
+		if program.pioVersion != nil {
+			c.raiseError("PIO version already defined", l[0])
+		}
+		if len(program.instructions) > 0 {
+			c.raiseError("`.pio_version` must precede the instructions", l[0])
+		}
+	}
+
+	ip := instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstPioVersion{token: l[0]}
+	if ip.peek() == itemSymbol {
+		if version, ok := pioVersionNames[strings.ToUpper(ip.line[0].val)]; ok {
+			lexItem := ip.next()
+			ast.name = strings.ToUpper(lexItem.val)
+			ast.version = &AstValue{token: lexItem, value: version}
+		}
+	}
+	if ast.version == nil {
+		ast.version = ip.expr()
+	}
+	ip.end()
+
+	return ast
+}
+
+func (c *compiler) parseInstruction(l line) *AstInstruction {
+	if c.currentProgram == nil {
+		c.raiseError("Instruction outside of a program", l[0])
+	}
+
+	ip := &instrParser{compiler: c, line: l[1:], last: l[0]}
+	ast := &AstInstruction{token: l[0]}
+
+	switch l[0].typ {
+	case itemInstrJMP:
+		ast.operation = ip.parseJmp()
+	case itemInstrWAIT:
+		ast.operation = ip.parseWait()
+	case itemInstrIN:
+		ast.operation = ip.parseIn()
+	case itemInstrOUT:
+		ast.operation = ip.parseOut()
+	case itemInstrPUSH, itemInstrPULL:
+		ast.operation = ip.parsePushPull(l[0])
+	case itemInstrMOV:
+		ast.operation = ip.parseMov()
+	case itemInstrIRQ:
+		ast.operation = ip.parseIrq()
+	case itemInstrSET:
+		ast.operation = ip.parseSet()
+	case itemInstrNOP:
+		ast.operation = &AstMov{nop: true, destination: itemY, source: itemY}
+	default:
+		c.raiseError("Unsupported instruction", l[0])
+	}
+
+	// Side-set and delay may come in any order
+	for ip.peek() != itemEOL {
+		if lexItem := ip.accept(itemSide); lexItem != nil {
+			if ast.sideSet != nil {
+				c.raiseError("Side-set already specified", lexItem)
+			}
+			ast.sideSet = ip.expr()
+		} else if lexItem := ip.accept(itemLBracket); lexItem != nil {
+			if ast.delay != nil {
+				c.raiseError("Delay already specified", lexItem)
+			}
+			ast.delay = ip.expr()
+			ip.expect(itemRBracket, "Expected `]`")
+		} else {
+			ip.end()
+		}
+	}
+
+	return ast
+}
+
+// evalExpr checks the identifiers of the expression and evaluates it.
+func (c *compiler) evalExpr(expr AstExpr) pioInt {
+	c.resolveExpr(expr)
+
+	return expr.eval(c)
+}
+
+// evalRange evaluates the expression and raises an error if the value is outside of min..max.
+func (c *compiler) evalRange(expr AstExpr, min pioInt, max pioInt, message string) pioInt {
+	value := c.evalExpr(expr)
+	if value < min || value > max {
+		c.raiseError(message, expr.start())
+	}
+
+	return value
+}
+
+// assemble encodes the instructions of every program.
+func (c *compiler) assemble(file *AstFile) {
+	version := c.options.PioVersion
+	if file.pioVersion != nil {
+		version = c.evalPioVersion(file.pioVersion)
+	}
+	for _, program := range file.programs {
+		program.version = version
+		if program.pioVersion != nil {
+			program.version = c.evalPioVersion(program.pioVersion)
+		}
+		c.assembleProgram(program)
+	}
+}
+
+func (c *compiler) evalPioVersion(pioVersion *AstPioVersion) int {
+	return int(c.evalRange(pioVersion.version, 0, 1, "PIO version must be 0 or 1"))
+}
+
+// requirePioV1 raises an error at the item of PIO version 1 syntax unless the program targets that version.
+func (c *compiler) requirePioV1(item *lexItem) {
+	if item != nil && c.pioVersion < 1 {
+		c.raiseError(fmt.Sprintf("`%s` requires PIO version 1", item.val), item)
+	}
+}
+
+func (c *compiler) assembleProgram(program *AstProgram) {
+	c.pioVersion = program.version
+	c.assembleConfig(program)
+	if sideSet := program.sideSet; sideSet != nil {
+		max := pioInt(5)
+		if sideSet.optional {
+			max = 4
+		}
+		sideSet.bits = int(c.evalRange(sideSet.count, 0, max, fmt.Sprintf("Side-set count must be in 0..%d", max)))
+	}
+
+	c.checkMemory(program)
+
+	program.assembler = make([]uint16, 0, len(program.instructions))
+	program.sourceFiles = make([]string, 0, len(program.instructions))
+	program.sourceLines = make([]int, 0, len(program.instructions))
+	for _, instruction := range program.instructions {
+		word := instruction.operation.encode(c) | c.encodeDelaySideSet(program, instruction)<<8
+		program.assembler = append(program.assembler, word)
+		file, line, _ := c.position(instruction.token)
+		program.sourceFiles = append(program.sourceFiles, file)
+		program.sourceLines = append(program.sourceLines, line)
+	}
+}
+
+// checkMemory raises an error if the program does not fit the instruction memory of a PIO block.
+func (c *compiler) checkMemory(program *AstProgram) {
+	if len(program.instructions) > MemorySize {
+		c.raiseError(fmt.Sprintf("Program exceeds the %d instructions of the memory", MemorySize),
+			program.instructions[MemorySize].token)
+	}
+	for _, label := range program.labels {
+		if label.index >= MemorySize {
+			c.raiseError(fmt.Sprintf("Label offset must be in 0..%d", MemorySize-1), label.token)
+		}
+	}
+	if origin := program.origin; origin != nil && origin.value+len(program.instructions) > MemorySize {
+		c.raiseError(fmt.Sprintf("Program of %d instructions does not fit at `.origin` %d",
+			len(program.instructions), origin.value), origin.token)
+	}
+}
+
+// encodeDelaySideSet returns the 5-bit field shared by the side-set value and the delay.
+func (c *compiler) encodeDelaySideSet(program *AstProgram, instruction *AstInstruction) uint16 {
+	sideSet := program.sideSet
+	delayBits := 5 - sideSet.bitsIncludingOpt()
+	var result uint16
+
+	if instruction.sideSet != nil {
+		if sideSet == nil {
+			c.raiseError("Side-set used without `.side_set`", instruction.sideSet.start())
+		}
+		max := pioInt(1)<<sideSet.bits - 1
+		value := c.evalRange(instruction.sideSet, 0, max, fmt.Sprintf("Side-set value must be in 0..%d", max))
+		result |= uint16(value) << delayBits
+		if sideSet.optional {
+			result |= 1 << 4
+		}
+	} else if sideSet != nil && !sideSet.optional {
+		c.raiseError("Side-set value required by `.side_set`", instruction.token)
+	}
+
+	if instruction.delay != nil {
+		max := pioInt(1)<<delayBits - 1
+		result |= uint16(c.evalRange(instruction.delay, 0, max, fmt.Sprintf("Delay must be in 0..%d", max)))
+	}
+
+	return result
+}
+
+type jmpCondition uint16
+
+const (
+	jmpAlways jmpCondition = iota
+	jmpNotX
+	jmpXDec
+	jmpNotY
+	jmpYDec
+	jmpXNotY
+	jmpPin
+	jmpNotOsre
+)
+
+var jmpConditionNames = [8]string{"", "!x", "x--", "!y", "y--", "x!=y", "pin", "!osre"}
+
+type AstJmp struct {
+	condition jmpCondition
+	target    AstExpr
+}
+
+func (a *AstJmp) ToSource() string {
+	if a.condition == jmpAlways {
+		return "jmp " + a.target.ToSource()
+	}
+
+	return fmt.Sprintf("jmp %s, %s", jmpConditionNames[a.condition], a.target.ToSource())
+}
+
+func (a *AstJmp) encode(c *compiler) uint16 {
+	target := c.evalRange(a.target, 0, 31, "Jump target must be in 0..31")
+
+	return opcodeJMP | uint16(a.condition)<<5 | uint16(target)
+}
+
+func (ip *instrParser) parseJmp() *AstJmp {
+	ast := &AstJmp{}
+	message := "Invalid `jmp` condition"
+	switch ip.peek() {
+	case itemBang, itemTilde:
+		ip.next()
+		switch lexItem := ip.next(); lexItem.typ {
+		case itemX:
+			ast.condition = jmpNotX
+		case itemY:
+			ast.condition = jmpNotY
+		case itemOSRE:
+			ast.condition = jmpNotOsre
+		default:
+			ip.compiler.raiseError(message, lexItem)
+		}
+	case itemX:
+		ip.next()
+		if ip.accept(itemBang) != nil {
+			ip.expect(itemEqual, message)
+			ip.expect(itemY, message)
+			ast.condition = jmpXNotY
+		} else {
+			ip.expect(itemMinus, message)
+			ip.expect(itemMinus, message)
+			ast.condition = jmpXDec
+		}
+	case itemY:
+		ip.next()
+		ip.expect(itemMinus, message)
+		ip.expect(itemMinus, message)
+		ast.condition = jmpYDec
+	case itemPin:
+		ip.next()
+		ast.condition = jmpPin
+	}
+	ip.comma()
+	ast.target = ip.expr()
+
+	return ast
+}
+
+type waitSource uint16
+
+const (
+	waitGPIO waitSource = iota
+	waitPin
+	waitIRQ
+	// PIO version 1
+	waitJmpPin
+)
+
+// irqIndexMode is the addressing of an IRQ flag; `prev` and `next` are PIO version 1.
+type irqIndexMode uint16
+
+const (
+	irqIndexThis irqIndexMode = iota
+	irqIndexPrev
+	irqIndexRel
+	irqIndexNext
+)
+
+type AstWait struct {
+	polarity AstExpr
+	source   waitSource
+	// index is nil for `jmppin` without an offset
+	index     AstExpr
+	indexMode irqIndexMode
+	// pioV1 is the first item of PIO version 1 syntax, nil if none
+	pioV1 *lexItem
+}
+
+func (a *AstWait) ToSource() string {
+	var b bytes.Buffer
+	b.WriteString(fmt.Sprintf("wait %s ", a.polarity.ToSource()))
+	switch a.source {
+	case waitGPIO:
+		b.WriteString("gpio " + a.index.ToSource())
+	case waitPin:
+		b.WriteString("pin " + a.index.ToSource())
+	case waitIRQ:
+		b.WriteString("irq " + irqIndexSource(a.index, a.indexMode))
+	case waitJmpPin:
+		b.WriteString("jmppin")
+		if a.index != nil {
+			b.WriteString(" + " + a.index.ToSource())
+		}
+	}
+
+	return b.String()
+}
+
+func (a *AstWait) encode(c *compiler) uint16 {
+	c.requirePioV1(a.pioV1)
+	polarity := c.evalRange(a.polarity, 0, 1, "Wait polarity must be 0 or 1")
+	var index uint16
+	switch a.source {
+	case waitIRQ:
+		index = c.encodeIrqIndex(a.index, a.indexMode)
+	case waitJmpPin:
+		if a.index != nil {
+			index = uint16(c.evalRange(a.index, 0, 3, "JMP pin offset must be in 0..3"))
+		}
+	default:
+		index = uint16(c.evalRange(a.index, 0, 31, "Wait index must be in 0..31"))
+	}
+
+	return opcodeWAIT | uint16(polarity)<<7 | uint16(a.source)<<5 | index
+}
+
+func (ip *instrParser) parseWait() *AstWait {
+	ast := &AstWait{polarity: ip.expr()}
+	switch lexItem := ip.next(); lexItem.typ {
+	case itemGPIO:
+		ast.source = waitGPIO
+		ast.index = ip.expr()
+	case itemPin:
+		ast.source = waitPin
+		ast.index = ip.expr()
+	case itemInstrIRQ:
+		ast.source = waitIRQ
+		ast.index, ast.indexMode, ast.pioV1 = ip.irqIndex()
+	case itemJmpPin:
+		ast.source = waitJmpPin
+		ast.pioV1 = lexItem
+		if ip.accept(itemPlus) != nil {
+			ast.index = ip.expr()
+		}
+	default:
+		ip.compiler.raiseError("Expected `gpio`, `pin`, `irq` or `jmppin`", lexItem)
+	}
+
+	return ast
+}
+
+// irqIndex parses an IRQ index with the optional `prev` or `next` prefix, or `rel` suffix.
+// The `prev` or `next` item is returned as it requires PIO version 1.
+func (ip *instrParser) irqIndex() (index AstExpr, mode irqIndexMode, pioV1 *lexItem) {
+	if pioV1 = ip.accept(itemPrev); pioV1 != nil {
+		mode = irqIndexPrev
+	} else if pioV1 = ip.accept(itemNext); pioV1 != nil {
+		mode = irqIndexNext
+	}
+	index = ip.expr()
+	if mode == irqIndexThis && ip.accept(itemRel) != nil {
+		mode = irqIndexRel
+	}
+
+	return
+}
+
+func irqIndexSource(index AstExpr, mode irqIndexMode) string {
+	switch mode {
+	case irqIndexPrev:
+		return "prev " + index.ToSource()
+	case irqIndexRel:
+		return index.ToSource() + " rel"
+	case irqIndexNext:
+		return "next " + index.ToSource()
+	}
+
+	return index.ToSource()
+}
+
+// encodeIrqIndex returns the 5-bit index field of `irq` and `wait irq`.
+func (c *compiler) encodeIrqIndex(index AstExpr, mode irqIndexMode) uint16 {
+	return uint16(mode)<<3 | uint16(c.evalRange(index, 0, 7, "IRQ index must be in 0..7"))
+}
+
+// operandNames are the spellings of the operand keywords used by ToSource.
+var operandNames = map[itemType]string{
+	itemPins:    "pins",
+	itemX:       "x",
+	itemY:       "y",
+	itemNull:    "null",
+	itemISR:     "isr",
+	itemOSR:     "osr",
+	itemPindirs: "pindirs",
+	itemPC:      "pc",
+	itemExec:    "exec",
+	itemStatus:  "status",
+}
+
+var inSources = map[itemType]uint16{
+	itemPins: 0,
+	itemX:    1,
+	itemY:    2,
+	itemNull: 3,
+	itemISR:  6,
+	itemOSR:  7,
+}
+
+var outDestinations = map[itemType]uint16{
+	itemPins:    0,
+	itemX:       1,
+	itemY:       2,
+	itemNull:    3,
+	itemPindirs: 4,
+	itemPC:      5,
+	itemISR:     6,
+	itemExec:    7,
+}
+
+// encodeBitCount checks the bit count of `in` and `out`. 32 is encoded as 0.
+func (c *compiler) encodeBitCount(expr AstExpr) uint16 {
+	return uint16(c.evalRange(expr, 1, 32, "Bit count must be in 1..32")) & 0x1f
+}
+
+type AstIn struct {
+	source   itemType
+	bitCount AstExpr
+}
+
+func (a *AstIn) ToSource() string {
+	return fmt.Sprintf("in %s, %s", operandNames[a.source], a.bitCount.ToSource())
+}
+
+func (a *AstIn) encode(c *compiler) uint16 {
+	return opcodeIN | inSources[a.source]<<5 | c.encodeBitCount(a.bitCount)
+}
+
+func (ip *instrParser) parseIn() *AstIn {
+	ast := &AstIn{source: ip.operand(inSources, "Invalid `in` source")}
+	ip.comma()
+	ast.bitCount = ip.expr()
+
+	return ast
+}
+
+type AstOut struct {
+	destination itemType
+	bitCount    AstExpr
+}
+
+func (a *AstOut) ToSource() string {
+	return fmt.Sprintf("out %s, %s", operandNames[a.destination], a.bitCount.ToSource())
+}
+
+func (a *AstOut) encode(c *compiler) uint16 {
+	return opcodeOUT | outDestinations[a.destination]<<5 | c.encodeBitCount(a.bitCount)
+}
+
+func (ip *instrParser) parseOut() *AstOut {
+	ast := &AstOut{destination: ip.operand(outDestinations, "Invalid `out` destination")}
+	ip.comma()
+	ast.bitCount = ip.expr()
+
+	return ast
+}
+
+type AstPushPull struct {
+	pull bool
+	// iffull for push, ifempty for pull
+	conditional bool
+	block       bool
+}
+
+func (a *AstPushPull) ToSource() string {
+	result := "push"
+	if a.pull {
+		result = "pull"
+	}
+	if a.conditional && a.pull {
+		result += " ifempty"
+	} else if a.conditional {
+		result += " iffull"
+	}
+	if !a.block {
+		result += " noblock"
+	}
+
+	return result
+}
+
+func (a *AstPushPull) encode(*compiler) uint16 {
+	result := opcodePUSHPULL
+	if a.pull {
+		result |= 1 << 7
+	}
+	if a.conditional {
+		result |= 1 << 6
+	}
+	if a.block {
+		result |= 1 << 5
+	}
+
+	return result
+}
+
+func (ip *instrParser) parsePushPull(instruction *lexItem) *AstPushPull {
+	ast := &AstPushPull{pull: instruction.typ == itemInstrPULL, block: true}
+	conditional := itemType(itemIfFull)
+	if ast.pull {
+		conditional = itemIfEmpty
+	}
+
+	var blocking *lexItem
+	for {
+		switch ip.peek() {
+		case conditional:
+			lexItem := ip.next()
+			if ast.conditional {
+				ip.compiler.raiseError(fmt.Sprintf("Duplicated `%s`", lexItem.val), lexItem)
+			}
+			ast.conditional = true
+		case itemBlock, itemNoBlock:
+			lexItem := ip.next()
+			if blocking != nil {
+				ip.compiler.raiseError(fmt.Sprintf("`%s` conflicts with `%s`", lexItem.val, blocking.val), lexItem)
+			}
+			blocking = lexItem
+			ast.block = lexItem.typ == itemBlock
+		case itemIfFull, itemIfEmpty:
+			lexItem := ip.next()
+			ip.compiler.raiseError(fmt.Sprintf("`%s` is not allowed for `%s`", lexItem.val, instruction.val), lexItem)
+		default:
+			return ast
+		}
+	}
+}
+
+var movDestinations = map[itemType]uint16{
+	itemPins: 0,
+	itemX:    1,
+	itemY:    2,
+	// PIO version 1
+	itemPindirs: 3,
+	itemExec:    4,
+	itemPC:      5,
+	itemISR:     6,
+	itemOSR:     7,
+}
+
+var movSources = map[itemType]uint16{
+	itemPins:   0,
+	itemX:      1,
+	itemY:      2,
+	itemNull:   3,
+	itemStatus: 5,
+	itemISR:    6,
+	itemOSR:    7,
+}
+
+type movOperation uint16
+
+const (
+	movNone movOperation = iota
+	movInvert
+	movReverse
+)
+
+// movOperationNames are the prefixes of the source; `~` is accepted as well as `!`.
+var movOperationNames = [3]string{"", "!", "::"}
+
+type AstMov struct {
+	// nop is `mov y, y` written as `nop`
+	nop bool
+	// destination or source is itemRxFifo for `mov rxfifo[], isr` and `mov osr, rxfifo[]`
+	destination itemType
+	operation   movOperation
+	source      itemType
+	// fifoIndex is the index of the RX FIFO entry, nil for `rxfifo[y]`
+	fifoIndex AstExpr
+	// pioV1 is the first item of PIO version 1 syntax, nil if none
+	pioV1 *lexItem
+}
+
+func (a *AstMov) ToSource() string {
+	if a.nop {
+		return "nop"
+	}
+
+	return fmt.Sprintf("mov %s, %s%s", a.operandSource(a.destination), movOperationNames[a.operation],
+		a.operandSource(a.source))
+}
+
+func (a *AstMov) operandSource(operand itemType) string {
+	if operand != itemRxFifo {
+		return operandNames[operand]
+	}
+	if a.fifoIndex == nil {
+		return "rxfifo[y]"
+	}
+
+	return fmt.Sprintf("rxfifo[%s]", a.fifoIndex.ToSource())
+}
+
+func (a *AstMov) encode(c *compiler) uint16 {
+	c.requirePioV1(a.pioV1)
+	if a.destination != itemRxFifo && a.source != itemRxFifo {
+		return opcodeMOV | movDestinations[a.destination]<<5 | uint16(a.operation)<<3 | movSources[a.source]
+	}
+
+	// The RX FIFO moves are encoded in the space of `push` and `pull`
+	result := opcodePUSHPULL | 1<<4
+	if a.source == itemRxFifo {
+		result |= 1 << 7
+	}
+	// The IdxI bit selects the index of the instruction, otherwise the entry is selected by `y`
+	if a.fifoIndex != nil {
+		result |= 1<<3 | uint16(c.evalRange(a.fifoIndex, 0, 3, "RX FIFO index must be in 0..3"))
+	}
+
+	return result
+}
+
+func (ip *instrParser) parseMov() *AstMov {
+	ast := &AstMov{}
+	if lexItem := ip.accept(itemRxFifo); lexItem != nil {
+		ast.pioV1 = lexItem
+		ast.destination = itemRxFifo
+		ast.fifoIndex = ip.fifoIndex()
+		ip.comma()
+		ip.expect(itemISR, "Expected `isr`")
+		ast.source = itemISR
+
+		return ast
+	}
+
+	ast.destination = ip.operand(movDestinations, "Invalid `mov` destination")
+	if ast.destination == itemPindirs {
+		ast.pioV1 = ip.last
+	}
+	ip.comma()
+	if lexItem := ip.accept(itemRxFifo); lexItem != nil {
+		if ast.destination != itemOSR {
+			ip.compiler.raiseError("`rxfifo` can only be moved to `osr`", lexItem)
+		}
+		ast.pioV1 = lexItem
+		ast.source = itemRxFifo
+		ast.fifoIndex = ip.fifoIndex()
+
+		return ast
+	}
+	switch ip.peek() {
+	case itemBang, itemTilde:
+		ip.next()
+		ast.operation = movInvert
+	case itemReverse:
+		ip.next()
+		ast.operation = movReverse
+	}
+	ast.source = ip.operand(movSources, "Invalid `mov` source")
+
+	return ast
+}
+
+// fifoIndex parses the `[y]` or `[index]` following `rxfifo`; nil stands for `y`.
+func (ip *instrParser) fifoIndex() AstExpr {
+	ip.expect(itemLBracket, "Expected `[`")
+	var result AstExpr
+	if ip.accept(itemY) == nil {
+		result = ip.expr()
+	}
+	ip.expect(itemRBracket, "Expected `]`")
+
+	return result
+}
+
+// irqModifiers are the `irq` modifiers with their clear and wait bits; `set` and `wait` are lexed as instructions.
+var irqModifiers = map[itemType]uint16{
+	itemInstrSET:  0,
+	itemNoWait:    0,
+	itemInstrWAIT: 1,
+	itemClear:     2,
+}
+
+var irqModifierNames = map[itemType]string{
+	itemInstrSET:  "set",
+	itemNoWait:    "nowait",
+	itemInstrWAIT: "wait",
+	itemClear:     "clear",
+}
+
+type AstIrq struct {
+	// modifier is 0 if omitted, which is the same as `set`
+	modifier  itemType
+	index     AstExpr
+	indexMode irqIndexMode
+	// pioV1 is the `prev` or `next` item, nil if none
+	pioV1 *lexItem
+}
+
+func (a *AstIrq) ToSource() string {
+	result := "irq "
+	if a.modifier != 0 {
+		result += irqModifierNames[a.modifier] + " "
+	}
+
+	return result + irqIndexSource(a.index, a.indexMode)
+}
+
+func (a *AstIrq) encode(c *compiler) uint16 {
+	c.requirePioV1(a.pioV1)
+
+	return opcodeIRQ | irqModifiers[a.modifier]<<5 | c.encodeIrqIndex(a.index, a.indexMode)
+}
+
+func (ip *instrParser) parseIrq() *AstIrq {
+	ast := &AstIrq{}
+	var modifier *lexItem
+	for {
+		if _, ok := irqModifiers[ip.peek()]; !ok {
+			break
+		}
+		lexItem := ip.next()
+		switch {
+		case modifier == nil:
+		case modifier.typ == lexItem.typ:
+			ip.compiler.raiseError(fmt.Sprintf("Duplicated `%s`", lexItem.val), lexItem)
+		case irqModifiers[modifier.typ]|irqModifiers[lexItem.typ] == 3:
+			ip.compiler.raiseError("`irq clear` cannot wait", lexItem)
+		default:
+			ip.compiler.raiseError(fmt.Sprintf("`%s` conflicts with `%s`", lexItem.val, modifier.val), lexItem)
+		}
+		modifier = lexItem
+		ast.modifier = lexItem.typ
+	}
+	ast.index, ast.indexMode, ast.pioV1 = ip.irqIndex()
+
+	return ast
+}
+
+var setDestinations = map[itemType]uint16{
+	itemPins:    0,
+	itemX:       1,
+	itemY:       2,
+	itemPindirs: 4,
+}
+
+type AstSet struct {
+	destination itemType
+	value       AstExpr
+}
+
+func (a *AstSet) ToSource() string {
+	return fmt.Sprintf("set %s, %s", operandNames[a.destination], a.value.ToSource())
+}
+
+func (a *AstSet) encode(c *compiler) uint16 {
+	value := c.evalRange(a.value, 0, 31, "Set value must be in 0..31")
+
+	return opcodeSET | setDestinations[a.destination]<<5 | uint16(value)
+}
+
+func (ip *instrParser) parseSet() *AstSet {
+	ast := &AstSet{destination: ip.operand(setDestinations, "Invalid `set` destination")}
+	ip.comma()
+	ast.value = ip.expr()
+
+	return ast
+}
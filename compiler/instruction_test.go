@@ -0,0 +1,982 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Compile_Instruction(t *testing.T) {
+	t.Run("Parses .side_set.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{".side_set 2 opt pindirs", "", 0},
+			{".side_set 5", "", 0},
+			{".side_set 5 opt", "Side-set count must be in 0..4", 11},
+			{".side_set 6", "Side-set count must be in 0..5", 11},
+			{".side_set 1 pindirs opt", "Unexpected item", 21},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if tc.message == "" {
+				if e != nil {
+					t.Errorf("%s: %#v", tc.source, e)
+				} else if got := ast.programs[0].sideSet.ToSource(); got != tc.source {
+					t.Errorf("%s != %s", got, tc.source)
+				}
+				continue
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Encodes wait.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"wait 0 gpio 0", 0x2000},
+			{"wait 1 gpio 31", 0x209f},
+			{"WAIT 1 PIN 2", 0x20a2},
+			{"wait 0 irq 7", 0x2047},
+			{"wait 1 irq 3 rel", 0x20d3},
+			{"wait 1 gpio BASE + 1", 0x2086},
+			{"wait 1 gpio 2 [31]", 0x3f82},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".define BASE 5\n.program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if wait operands are out of range.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"wait 2 gpio 1", "Wait polarity must be 0 or 1", 6},
+			{"wait -1 gpio 1", "Wait polarity must be 0 or 1", 6},
+			{"wait 1 gpio 32", "Wait index must be in 0..31", 13},
+			{"wait 1 pin -1", "Wait index must be in 0..31", 12},
+			{"wait 1 irq 8", "IRQ index must be in 0..7", 12},
+			{"wait 1 x 8", "Expected `gpio`, `pin`, `irq` or `jmppin`", 8},
+			{"wait 1 gpio 1 rel", "Unexpected item", 15},
+			{"wait 1 gpio", "Unexpected end of expression", 8},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates instructions.", func(t *testing.T) {
+		source := `
+.program test
+.side_set 1 opt pindirs
+.define D 2
+	wait 1 gpio D + 1 side 1 [D]
+	wait 0 irq 1 rel [1] side 0
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.side_set 1 opt pindirs
+.define D 2
+	wait 1 gpio D + 1 side 1 [D]
+	wait 0 irq 1 rel side 0 [1]
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes side-set and delay.", func(t *testing.T) {
+		cases := []struct {
+			sideSet string
+			source  string
+			word    uint16
+		}{
+			{".side_set 1", "wait 0 gpio 0 side 1 [15]", 0x3f00},
+			{".side_set 5", "wait 0 gpio 0 side 31", 0x3f00},
+			{".side_set 1 opt", "wait 0 gpio 0 side 0 [7]", 0x3700},
+			{".side_set 1 opt", "wait 0 gpio 0 [7]", 0x2700},
+			{".side_set 4 opt", "wait 0 gpio 0 side 15", 0x3f00},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n%s\n", tc.sideSet, tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if side-set or delay are invalid.", func(t *testing.T) {
+		cases := []struct {
+			sideSet string
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{"", "wait 0 gpio 0 side 1", "Side-set used without `.side_set`", 3, 20},
+			{".side_set 1", "wait 0 gpio 0", "Side-set value required by `.side_set`", 3, 1},
+			{".side_set 1", "wait 0 gpio 0 side 2", "Side-set value must be in 0..1", 3, 20},
+			{".side_set 1", "wait 0 gpio 0 side 1 [16]", "Delay must be in 0..15", 3, 23},
+			{".side_set 2 opt", "wait 0 gpio 0 [4]", "Delay must be in 0..3", 3, 16},
+			{".side_set 5 opt", "wait 0 gpio 0", "Side-set count must be in 0..4", 2, 11},
+			{".side_set 6", "wait 0 gpio 0 side 0", "Side-set count must be in 0..5", 2, 11},
+			{"", "wait 0 gpio 0 [1] [1]", "Delay already specified", 3, 19},
+			{"", "wait 0 gpio 0 [1", "Expected `]`", 3, 16},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n%s\n", tc.sideSet, tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Error if instruction is outside of a program.", func(t *testing.T) {
+		source := `wait 0 gpio 0`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "Instruction outside of a program" || e.line != 1 || e.offset != 1 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Error if .side_set follows instructions.", func(t *testing.T) {
+		source := `
+.program test
+	wait 0 gpio 0
+.side_set 1
+`
+
+		ast, e := Compile(source, &Options{})
+
+		if ast != nil {
+			t.Errorf("%#v", ast)
+		}
+		if e == nil || e.message != "`.side_set` must precede the instructions" || e.line != 4 || e.offset != 1 {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Encodes in and out.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"in pins, 1", 0x4001},
+			{"in x, 2", 0x4022},
+			{"in y, 31", 0x405f},
+			{"in null, 32", 0x4060},
+			{"in isr, 8", 0x40c8},
+			{"IN OSR 16", 0x40f0},
+			{"out pins, 1", 0x6001},
+			{"out x, 1", 0x6021},
+			{"out y, 32", 0x6040},
+			{"out null, 4", 0x6064},
+			{"out pindirs, 2", 0x6082},
+			{"out pc, 5", 0x60a5},
+			{"out isr, 3", 0x60c3},
+			{"out exec, 16", 0x60f0},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if in or out operands are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"in pc, 1", "Invalid `in` source", 4},
+			{"in exec, 1", "Invalid `in` source", 4},
+			{"in pindirs, 1", "Invalid `in` source", 4},
+			{"in status, 1", "Invalid `in` source", 4},
+			{"in 1, 1", "Invalid `in` source", 4},
+			{"in", "Invalid `in` source", 1},
+			{"out osr, 1", "Invalid `out` destination", 5},
+			{"out status, 1", "Invalid `out` destination", 5},
+			{"in x, 0", "Bit count must be in 1..32", 7},
+			{"out x, 33", "Bit count must be in 1..32", 8},
+			{"out x, 1, 2", "Unexpected item", 9},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates in and out.", func(t *testing.T) {
+		source := `
+.program test
+.define N 8
+	in pins N
+	out pindirs, N * 4
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.define N 8
+	in pins, N
+	out pindirs, N * 4
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes push and pull.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"push", 0x8020},
+			{"push block", 0x8020},
+			{"push noblock", 0x8000},
+			{"push iffull", 0x8060},
+			{"push iffull noblock", 0x8040},
+			{"PUSH NOBLOCK IFFULL", 0x8040},
+			{"pull", 0x80a0},
+			{"pull noblock", 0x8080},
+			{"pull ifempty", 0x80e0},
+			{"pull ifempty noblock", 0x80c0},
+			{"pull noblock [1]", 0x8180},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if push or pull modifiers conflict.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"push block noblock", "`noblock` conflicts with `block`", 12},
+			{"pull noblock noblock", "`noblock` conflicts with `noblock`", 14},
+			{"push iffull iffull", "Duplicated `iffull`", 13},
+			{"push ifempty", "`ifempty` is not allowed for `push`", 6},
+			{"pull iffull", "`iffull` is not allowed for `pull`", 6},
+			{"pull x", "Unexpected item", 6},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates push and pull.", func(t *testing.T) {
+		source := `
+.program test
+	push block
+	push iffull noblock
+	pull ifempty
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+	push
+	push iffull noblock
+	pull ifempty
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes mov and nop.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"mov pins, x", 0xa001},
+			{"mov x, y", 0xa022},
+			{"mov y, null", 0xa043},
+			{"mov exec, isr", 0xa086},
+			{"mov pc, osr", 0xa0a7},
+			{"mov isr, status", 0xa0c5},
+			{"mov osr, pins", 0xa0e0},
+			{"mov x, !x", 0xa029},
+			{"mov x, ~x", 0xa029},
+			{"mov x ~ x", 0xa029},
+			{"mov isr, ::osr", 0xa0d7},
+			{"nop", 0xa042},
+			{"nop [7]", 0xa742},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if mov operands are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"mov null, x", "Invalid `mov` destination", 5},
+			{"mov pindirs, x", "`pindirs` requires PIO version 1", 5},
+			{"mov status, x", "Invalid `mov` destination", 5},
+			{"mov x, pc", "Invalid `mov` source", 8},
+			{"mov x, !exec", "Invalid `mov` source", 9},
+			{"mov x, ! :: y", "Invalid `mov` source", 10},
+			{"mov x,", "Invalid `mov` source", 6},
+			{"nop x", "Unexpected item", 5},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates mov and nop.", func(t *testing.T) {
+		source := `
+.program test
+	mov x ~y
+	mov pins, ::isr
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+	mov x, !y
+	mov pins, ::isr
+	nop
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes irq.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"irq 0", 0xc000},
+			{"irq set 7", 0xc007},
+			{"irq nowait 3", 0xc003},
+			{"irq wait 1", 0xc021},
+			{"irq clear 2", 0xc042},
+			{"irq 2 rel", 0xc012},
+			{"IRQ WAIT 3 REL", 0xc033},
+			{"irq clear BASE + 2 rel [1]", 0xc157},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".define BASE 5\n.program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if irq operands are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"irq 8", "IRQ index must be in 0..7", 5},
+			{"irq clear -1", "IRQ index must be in 0..7", 11},
+			{"irq clear wait 1", "`irq clear` cannot wait", 11},
+			{"irq wait clear 1", "`irq clear` cannot wait", 10},
+			{"irq wait wait 1", "Duplicated `wait`", 10},
+			{"irq set nowait 1", "`nowait` conflicts with `set`", 9},
+			{"irq 1 x", "Unexpected item", 7},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates irq.", func(t *testing.T) {
+		source := `
+.program test
+.define N 1
+	irq N
+	IRQ Wait N + 1 rel
+	irq clear 0
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.define N 1
+	irq N
+	irq wait N + 1 rel
+	irq clear 0
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes set.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"set pins, 0", 0xe000},
+			{"set x, 31", 0xe03f},
+			{"set y 5", 0xe045},
+			{"set pindirs, 1", 0xe081},
+			{"set pins, LED_ON", 0xe001},
+			{"set x, LED_ON << 4 | 0xf", 0xe03f},
+			{"set pins, LED_ON [2]", 0xe201},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".define LED_ON 1\n.program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if set operands are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"set pc, 1", "Invalid `set` destination", 5},
+			{"set null, 1", "Invalid `set` destination", 5},
+			{"set exec, 1", "Invalid `set` destination", 5},
+			{"set x, 32", "Set value must be in 0..31", 8},
+			{"set x, 1 - 2", "Set value must be in 0..31", 8},
+			{"set x, 1, 2", "Unexpected item", 9},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates set.", func(t *testing.T) {
+		source := `
+.program test
+.define LED_ON 1
+	set pins LED_ON
+	set pindirs, (LED_ON + 1) * 2
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+.define LED_ON 1
+	set pins, LED_ON
+	set pindirs, (LED_ON + 1) * 2
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes jmp.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"jmp 3", 0x0003},
+			{"jmp !x, 1", 0x0021},
+			{"jmp x--, 2", 0x0042},
+			{"jmp ~y 3", 0x0063},
+			{"jmp y--, 4", 0x0084},
+			{"jmp x != y, 5", 0x00a5},
+			{"jmp pin, N", 0x00c6},
+			{"jmp !osre, 31", 0x00ff},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n.define N 6\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if jmp operands are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"jmp 32", "Jump target must be in 0..31", 5},
+			{"jmp !z, 0", "Invalid `jmp` condition", 6},
+			{"jmp x-, 0", "Invalid `jmp` condition", 7},
+			{"jmp missing", "Unknown identifier in expression", 5},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 2 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Resolves labels.", func(t *testing.T) {
+		source := `.program test
+start:
+	jmp end
+public loop: set x, 1
+	jmp x--, loop
+end:
+	jmp start
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+		program := ast.Program("test")
+		expected := []uint16{0x0003, 0xe021, 0x0041, 0x0000}
+		if got := program.Instructions(); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%x != %x", got, expected)
+		}
+		if index, ok := program.Label("loop"); !ok || index != 1 {
+			t.Errorf("loop: %d, %t", index, ok)
+		}
+		if _, ok := program.Label("missing"); ok {
+			t.Errorf("missing label found")
+		}
+	})
+
+	t.Run("Error if labels are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+		}{
+			{"a:\n", "`a:` outside of a program", 1},
+			{".program test\na:\na:\nnop\n", "Symbol already defined", 3},
+			{".program test\npublic nop\n", "Expected a label", 2},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != 1 {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Error if the program does not fit the instruction memory.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+		}{
+			{strings.Repeat("nop\n", 33), "Program exceeds the 32 instructions of the memory", 34},
+			{strings.Repeat("nop\n", 32) + "end:\n", "Label offset must be in 0..31", 34},
+			{".origin 30\n" + strings.Repeat("nop\n", 3), "Program of 3 instructions does not fit at `.origin` 30", 2},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(".program test\n"+tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != 1 {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+
+		if _, e := Compile(".program test\n.origin 29\n"+strings.Repeat("nop\n", 3)+"end:\n", &Options{}); e != nil {
+			t.Errorf("%#v", e)
+		}
+		if _, e := Compile(".program test\n"+strings.Repeat("nop\n", 32), &Options{}); e != nil {
+			t.Errorf("%#v", e)
+		}
+	})
+
+	t.Run("Regenerates jmp and labels.", func(t *testing.T) {
+		source := `.program test
+start:
+	jmp end
+public loop: set x, 1
+	jmp x!=y, loop
+end:
+`
+		ast, _ := Compile(source, &Options{})
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.program test
+start:
+	jmp end
+public loop:
+	set x, 1
+	jmp x!=y, loop
+end:
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+
+	t.Run("Encodes PIO version 1 instructions.", func(t *testing.T) {
+		cases := []struct {
+			source string
+			word   uint16
+		}{
+			{"wait 1 jmppin", 0x20e0},
+			{"wait 0 jmppin + 3", 0x2063},
+			{"wait 1 irq prev 2", 0x20ca},
+			{"wait 1 irq next 2", 0x20da},
+			{"irq prev 1", 0xc009},
+			{"irq clear next 7", 0xc05f},
+			{"mov pindirs, ~x", 0xa069},
+			{"mov rxfifo[y], isr", 0x8010},
+			{"mov rxfifo[2], isr", 0x801a},
+			{"mov osr, rxfifo[y]", 0x8090},
+			{"mov osr rxfifo[N]", 0x809b},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".pio_version 1\n.define N 3\n.program test\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+			if got := ast.programs[0].assembler[0]; got != tc.word {
+				t.Errorf("%s: 0x%04x != 0x%04x", tc.source, got, tc.word)
+			}
+		}
+	})
+
+	t.Run("Error if PIO version 1 instructions are invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"wait 1 jmppin + 4", "JMP pin offset must be in 0..3", 17},
+			{"irq prev 1 rel", "Unexpected item", 12},
+			{"mov rxfifo[4], isr", "RX FIFO index must be in 0..3", 12},
+			{"mov rxfifo[y], osr", "Expected `isr`", 16},
+			{"mov rxfifo y, isr", "Expected `[`", 12},
+			{"mov rxfifo[y, isr", "Expected `]`", 13},
+			{"mov x, rxfifo[y]", "`rxfifo` can only be moved to `osr`", 8},
+		}
+
+		for _, tc := range cases {
+			source := fmt.Sprintf(".program test\n.pio_version 1\n%s\n", tc.source)
+			ast, e := Compile(source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != 3 || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Error if PIO version 1 syntax is used with version 0.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			offset  int
+		}{
+			{"wait 1 jmppin", "`jmppin` requires PIO version 1", 8},
+			{"wait 1 irq next 2", "`next` requires PIO version 1", 12},
+			{"irq PREV 1", "`PREV` requires PIO version 1", 5},
+			{"mov pindirs, x", "`pindirs` requires PIO version 1", 5},
+			{"mov rxfifo[y], isr", "`rxfifo` requires PIO version 1", 5},
+			{"mov osr, rxfifo[0]", "`rxfifo` requires PIO version 1", 10},
+		}
+
+		for _, tc := range cases {
+			for _, version := range []string{"", ".pio_version 0\n", ".pio_version RP2040\n"} {
+				source := fmt.Sprintf("%s.program test\n%s\n", version, tc.source)
+				ast, e := Compile(source, &Options{})
+				line := 2
+				if version != "" {
+					line = 3
+				}
+
+				if ast != nil {
+					t.Errorf("%s: %#v", tc.source, ast)
+				}
+				if e == nil || e.message != tc.message || e.line != line || e.offset != tc.offset {
+					t.Errorf("%s: %#v", tc.source, e)
+				}
+			}
+		}
+	})
+
+	t.Run("Selects the PIO version.", func(t *testing.T) {
+		cases := []struct {
+			name    string
+			source  string
+			options *Options
+			version int
+		}{
+			{"default", ".program test\nnop\n", &Options{}, 0},
+			{"options", ".program test\nnop\n", &Options{PioVersion: 1}, 1},
+			{"file", ".pio_version 1\n.program test\nnop\n", &Options{}, 1},
+			{"chip name", ".pio_version rp2350\n.program test\nnop\n", &Options{}, 1},
+			{"program", ".pio_version 1\n.program test\n.pio_version 0\nnop\n", &Options{PioVersion: 1}, 0},
+			{"define", ".define V 1\n.program test\n.pio_version V\nnop\n", &Options{}, 1},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, tc.options)
+
+			if e != nil {
+				t.Errorf("%s: %#v", tc.name, e)
+				continue
+			}
+			if got := ast.programs[0].version; got != tc.version {
+				t.Errorf("%s: %d != %d", tc.name, got, tc.version)
+			}
+		}
+	})
+
+	t.Run("Error if the PIO version is invalid.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+			line    int
+			offset  int
+		}{
+			{".pio_version 2\n.program test\n", "PIO version must be 0 or 1", 1, 14},
+			{".pio_version RP2040 1\n", "Unexpected item", 1, 21},
+			{".pio_version 0\n.pio_version 1\n", "PIO version already defined", 2, 1},
+			{".program test\n.pio_version 0\n.pio_version 1\n", "PIO version already defined", 3, 1},
+			{".program test\nnop\n.pio_version 1\n", "`.pio_version` must precede the instructions", 3, 1},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(tc.source, &Options{})
+
+			if ast != nil {
+				t.Errorf("%s: %#v", tc.source, ast)
+			}
+			if e == nil || e.message != tc.message || e.line != tc.line || e.offset != tc.offset {
+				t.Errorf("%s: %#v", tc.source, e)
+			}
+		}
+	})
+
+	t.Run("Regenerates PIO version 1 instructions.", func(t *testing.T) {
+		source := `
+.pio_version rp2350
+.program test
+.pio_version 1
+	wait 1 jmppin + 1
+	wait 0 irq prev 2
+	irq next 3
+	mov pindirs, !x
+	mov rxfifo[y], isr
+	mov osr, rxfifo[1 + 1]
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		sourceOut := ast.ToSource()
+
+		if sourceOut != `.pio_version RP2350
+.program test
+.pio_version 1
+	wait 1 jmppin + 1
+	wait 0 irq prev 2
+	irq next 3
+	mov pindirs, !x
+	mov rxfifo[y], isr
+	mov osr, rxfifo[1 + 1]
+` {
+			t.Logf(sourceOut)
+			t.Errorf("Regenerated source is different")
+		}
+	})
+}
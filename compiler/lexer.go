@@ -3,6 +3,7 @@ package compiler
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -14,6 +15,8 @@ type lexItem struct {
 	val   string
 	end   int
 	start int
+	// lex is the lexer of the file the item comes from
+	lex *lexer
 }
 
 type itemType int
@@ -23,6 +26,7 @@ const (
 	itemEOF
 	itemEOL
 	itemNumber
+	itemFloat
 	itemSymbol
 	itemDirDefine
 	itemDirProgram
@@ -32,6 +36,14 @@ const (
 	itemDirWrap
 	itemDirLangOpt
 	itemDirWord
+	itemDirPioVersion
+	itemDirIn
+	itemDirOut
+	itemDirSet
+	itemDirFifo
+	itemDirMovStatus
+	itemDirClockDiv
+	itemDirInclude
 	itemInstrJMP
 	itemInstrWAIT
 	itemInstrIN
@@ -48,6 +60,37 @@ const (
 	itemPin
 	itemGPIO
 	itemOSRE
+	itemRel
+	itemPins
+	itemPindirs
+	itemX
+	itemY
+	itemNull
+	itemISR
+	itemOSR
+	itemPC
+	itemExec
+	itemStatus
+	itemIfFull
+	itemIfEmpty
+	itemBlock
+	itemNoBlock
+	itemClear
+	itemNoWait
+	itemJmpPin
+	itemPrev
+	itemNext
+	itemRxFifo
+	itemTxFifo
+	itemLeft
+	itemRight
+	itemAuto
+	itemTxRx
+	itemTx
+	itemRx
+	itemTxPut
+	itemTxGet
+	itemPutGet
 
 	itemReverse
 	itemComma
@@ -63,8 +106,14 @@ const (
 	itemBinAnd
 	itemBinOr
 	itemBinXor
+	itemShiftLeft
+	itemShiftRight
+	itemLess
 	itemBang
+	itemTilde
 	itemEqual
+	itemCodeBlock
+	itemString
 )
 
 const (
@@ -80,12 +129,17 @@ const (
 	binAnd    = '&'
 	binOr     = '|'
 	binXor    = '^'
+	less      = '<'
+	greater   = '>'
 	lBracket  = '['
 	rBracket  = ']'
 	lParent   = '('
 	rParent   = ')'
 	bang      = '!'
+	tilde     = '~'
 	equal     = '='
+	percent   = '%'
+	quote     = '"'
 
 	eof = 0
 )
@@ -115,6 +169,8 @@ type lexer struct {
 	width    int
 	items    chan lexItem
 	lastItem *lexItem
+	// included is set for the files read by `.include`
+	included bool
 }
 
 func lex(name, input string) (*lexer, chan lexItem) {
@@ -147,14 +203,10 @@ func (l *lexer) position(pos int) (line int, offset int) {
 		line = linesLen - 1
 	}
 
-	lineOffset := 0
+	offset = pos + 1
 	if line >= 0 {
-		lineOffset = l.lines[line]
-	}
-
-	offset = pos - lineOffset
-	if lineOffset == 0 {
-		offset += 1
+		// Lines start after the newline so the offset is 1-based already
+		offset = pos - l.lines[line]
 	}
 	line += 2
 
@@ -162,7 +214,7 @@ func (l *lexer) position(pos int) (line int, offset int) {
 }
 
 func (l *lexer) emit(t itemType) {
-	item := lexItem{typ: t, val: l.input[l.start:l.pos], start: l.start, end: l.pos}
+	item := lexItem{typ: t, val: l.input[l.start:l.pos], start: l.start, end: l.pos, lex: l}
 	// Don't put item if the last item was eol and current is eol
 	// or there was no last item (current item is the first one) and it is an EOL
 	if !(item.typ == itemEOL && (l.lastItem == nil || l.lastItem != nil && l.lastItem.typ == itemEOL)) {
@@ -207,13 +259,21 @@ func (l *lexer) acceptStringCI(s string) bool {
 			return false
 		}
 	}
-	if isWordEnd(l.peek()) {
+	// A keyword followed by a colon is lexed as a label, e.g. `next:`, so the parser can reject it
+	if isWordEnd(l.peek()) && !l.atLabelEnd() {
 		return true
 	}
 	l.back()
 	return false
 }
 
+// atLabelEnd tells if the next rune is the colon ending a label and not the `::` operator.
+func (l *lexer) atLabelEnd() bool {
+	rest := l.input[l.pos:]
+
+	return strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, "::")
+}
+
 func (l *lexer) peek() (rune rune) {
 	if l.pos >= len(l.input) {
 		return eof
@@ -244,7 +304,8 @@ func isWhite(r rune) bool {
 }
 
 func isWordEnd(r rune) bool {
-	return isWhite(r)
+	// Keywords may be directly followed by punctuation, e.g. `x,` or `y--`
+	return !isSymbol(r) && !unicode.IsNumber(r) && r != dot
 }
 
 func lexContent(l *lexer) stateFn {
@@ -297,6 +358,99 @@ func lexContent(l *lexer) stateFn {
 		} else if l.acceptStringCI("OSRE") {
 			l.emit(itemOSRE)
 			return lexContent
+		} else if l.acceptStringCI("REL") {
+			l.emit(itemRel)
+			return lexContent
+		} else if l.acceptStringCI("PINS") {
+			l.emit(itemPins)
+			return lexContent
+		} else if l.acceptStringCI("PINDIRS") {
+			l.emit(itemPindirs)
+			return lexContent
+		} else if l.acceptStringCI("X") {
+			l.emit(itemX)
+			return lexContent
+		} else if l.acceptStringCI("Y") {
+			l.emit(itemY)
+			return lexContent
+		} else if l.acceptStringCI("NULL") {
+			l.emit(itemNull)
+			return lexContent
+		} else if l.acceptStringCI("ISR") {
+			l.emit(itemISR)
+			return lexContent
+		} else if l.acceptStringCI("OSR") {
+			l.emit(itemOSR)
+			return lexContent
+		} else if l.acceptStringCI("PC") {
+			l.emit(itemPC)
+			return lexContent
+		} else if l.acceptStringCI("EXEC") {
+			l.emit(itemExec)
+			return lexContent
+		} else if l.acceptStringCI("STATUS") {
+			l.emit(itemStatus)
+			return lexContent
+		} else if l.acceptStringCI("IFFULL") {
+			l.emit(itemIfFull)
+			return lexContent
+		} else if l.acceptStringCI("IFEMPTY") {
+			l.emit(itemIfEmpty)
+			return lexContent
+		} else if l.acceptStringCI("BLOCK") {
+			l.emit(itemBlock)
+			return lexContent
+		} else if l.acceptStringCI("NOBLOCK") {
+			l.emit(itemNoBlock)
+			return lexContent
+		} else if l.acceptStringCI("CLEAR") {
+			l.emit(itemClear)
+			return lexContent
+		} else if l.acceptStringCI("NOWAIT") {
+			l.emit(itemNoWait)
+			return lexContent
+		} else if l.acceptStringCI("JMPPIN") {
+			l.emit(itemJmpPin)
+			return lexContent
+		} else if l.acceptStringCI("PREV") {
+			l.emit(itemPrev)
+			return lexContent
+		} else if l.acceptStringCI("NEXT") {
+			l.emit(itemNext)
+			return lexContent
+		} else if l.acceptStringCI("RXFIFO") {
+			l.emit(itemRxFifo)
+			return lexContent
+		} else if l.acceptStringCI("TXFIFO") {
+			l.emit(itemTxFifo)
+			return lexContent
+		} else if l.acceptStringCI("LEFT") {
+			l.emit(itemLeft)
+			return lexContent
+		} else if l.acceptStringCI("RIGHT") {
+			l.emit(itemRight)
+			return lexContent
+		} else if l.acceptStringCI("AUTO") {
+			l.emit(itemAuto)
+			return lexContent
+		} else if l.acceptStringCI("TXRX") {
+			l.emit(itemTxRx)
+			return lexContent
+		} else if l.acceptStringCI("TXPUT") {
+			l.emit(itemTxPut)
+			return lexContent
+		} else if l.acceptStringCI("TXGET") {
+			l.emit(itemTxGet)
+			return lexContent
+		} else if l.acceptStringCI("PUTGET") {
+			l.emit(itemPutGet)
+			return lexContent
+		} else if l.acceptStringCI("TX") {
+			l.emit(itemTx)
+			return lexContent
+		} else if l.acceptStringCI("RX") {
+			l.emit(itemRx)
+			return lexContent
 		}
 
 		next := l.next()
@@ -342,12 +496,32 @@ func lexContent(l *lexer) stateFn {
 			l.emit(itemBinOr)
 		} else if next == binXor {
 			l.emit(itemBinXor)
+		} else if next == less && l.peek() == less {
+			l.next()
+			l.emit(itemShiftLeft)
+		} else if next == less {
+			l.emit(itemLess)
+		} else if next == greater && l.peek() == greater {
+			l.next()
+			l.emit(itemShiftRight)
 		} else if next == bang {
 			l.emit(itemBang)
+		} else if next == tilde {
+			l.emit(itemTilde)
 		} else if next == equal {
 			l.emit(itemEqual)
+		} else if next == percent {
+			return lexCodeBlock
+		} else if next == quote {
+			return lexString
 		} else if next == comma {
 			l.emit(itemComma)
+		} else if next == colon && l.peek() == colon {
+			l.next()
+			l.emit(itemReverse)
+		} else {
+			l.emit(itemError)
+			return nil
 		}
 	}
 }
@@ -357,7 +531,6 @@ func lexSymbolOrLabel(l *lexer) stateFn {
 		next := l.next()
 		if next != dot && !unicode.IsNumber(next) && !isSymbol(next) {
 			if next == colon {
-				// TODO "public label:"
 				l.emit(itemLabel)
 			} else {
 				l.backup()
@@ -391,6 +564,25 @@ func lexDirective(l *lexer) stateFn {
 				l.emit(itemDirLangOpt)
 			case ".word":
 				l.emit(itemDirWord)
+			case ".pio_version":
+				l.emit(itemDirPioVersion)
+			case ".in":
+				l.emit(itemDirIn)
+			case ".out":
+				l.emit(itemDirOut)
+			case ".set":
+				l.emit(itemDirSet)
+			case ".fifo":
+				l.emit(itemDirFifo)
+			case ".mov_status":
+				l.emit(itemDirMovStatus)
+			case ".clock_div":
+				l.emit(itemDirClockDiv)
+			case ".include":
+				l.emit(itemDirInclude)
+			default:
+				l.emit(itemError)
+				return nil
 			}
 			return lexContent
 		}
@@ -398,11 +590,33 @@ func lexDirective(l *lexer) stateFn {
 }
 
 func lexValue(l *lexer) stateFn {
-	// TODO hex (0xf), binary (0b1001)
+	digit := isValue
+	decimal := true
+	if l.input[l.start] == '0' {
+		switch l.peek() {
+		case 'x', 'X':
+			l.next()
+			digit = isHexDigit
+			decimal = false
+		case 'b', 'B':
+			l.next()
+			digit = isBinDigit
+			decimal = false
+		}
+	}
+	if l.pos-l.start > 1 && !digit(l.peek()) {
+		l.emit(itemError)
+		return nil
+	}
+
 	for {
 		next := l.next()
-		if !isValue(next) {
-			if isSymbol(next) {
+		if decimal && next == dot && isValue(l.peek()) {
+			return lexFraction
+		}
+		if !digit(next) {
+			// A letter or a digit out of the base, e.g. `0b102`, is part of the literal
+			if isSymbol(next) || isValue(next) {
 				l.emit(itemError)
 				return nil
 			}
@@ -413,6 +627,64 @@ func lexValue(l *lexer) stateFn {
 	}
 }
 
+// lexFraction lexes the digits following the decimal point of a float, e.g. of `.clock_div 2.5`.
+func lexFraction(l *lexer) stateFn {
+	for {
+		next := l.next()
+		if !isValue(next) {
+			if isSymbol(next) || next == dot {
+				l.emit(itemError)
+				return nil
+			}
+			l.backup()
+			l.emit(itemFloat)
+			return lexContent
+		}
+	}
+}
+
+// lexCodeBlock lexes `% <lang> {` up to the line starting with `%}` as a single item.
+func lexCodeBlock(l *lexer) stateFn {
+	for next := l.next(); next != eol; next = l.next() {
+		if next == eof {
+			l.emit(itemError)
+			return nil
+		}
+	}
+
+	for {
+		rest := l.input[l.pos:]
+		if strings.HasPrefix(strings.TrimLeft(rest, " \t"), "%}") {
+			for l.next() != percent {
+			}
+			l.next()
+			l.emit(itemCodeBlock)
+			return lexContent
+		}
+		for next := l.next(); next != eol; next = l.next() {
+			if next == eof {
+				l.emit(itemError)
+				return nil
+			}
+		}
+	}
+}
+
+// lexString lexes a string in double quotes, e.g. the file of `.include`. The string ends on the line.
+func lexString(l *lexer) stateFn {
+	for {
+		next := l.next()
+		if next == quote {
+			l.emit(itemString)
+			return lexContent
+		}
+		if isEOL(next) || isEOF(next) {
+			l.emit(itemError)
+			return nil
+		}
+	}
+}
+
 func lexComment(l *lexer) stateFn {
 	for {
 		peek := l.peek()
@@ -430,10 +702,28 @@ func isSymbol(r rune) bool {
 	return unicode.IsLetter(r) || r == '_'
 }
 
+// isReserved tells if the word is a keyword, e.g. `next` or `x`, and can't name a label.
+func isReserved(word string) bool {
+	_, items := lex("", word)
+	item := <-items
+	for range items {
+	}
+
+	return item.typ != itemSymbol
+}
+
 func isValue(r rune) bool {
 	return unicode.IsNumber(r)
 }
 
+func isHexDigit(r rune) bool {
+	return isValue(r) || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+}
+
+func isBinDigit(r rune) bool {
+	return r == '0' || r == '1'
+}
+
 func isDirective(r rune) bool {
 	return r == dot
 }
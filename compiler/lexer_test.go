@@ -203,25 +203,317 @@ set`
 		}
 	})
 
-	//	t.Run("Parse 16-based integer.", func(t *testing.T) {
-	//		input := `
-	//0xffee
-	//`
-	//
-	//		_, itemsCh := lex("test", input)
-	//		items := make([]lexItem, 0)
-	//		for {
-	//			item, ok := <-itemsCh
-	//			if !ok {
-	//				break
-	//			}
-	//			t.Logf("%#v\n", item)
-	//			items = append(items, item)
-	//		}
-	//		if items[0].typ != itemNumber {
-	//			t.Error()
-	//		}
-	//	})
+	t.Run("Parse 16-based integer.", func(t *testing.T) {
+		input := `
+0xffee
+`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		if items[0].typ != itemNumber || items[0].val != "0xffee" {
+			t.Error()
+		}
+	})
+
+	t.Run("Parse 2-based integer.", func(t *testing.T) {
+		input := `
+0b1011
+`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		if items[0].typ != itemNumber || items[0].val != "0b1011" {
+			t.Error()
+		}
+	})
+
+	t.Run("Error if a prefixed integer has no digits.", func(t *testing.T) {
+		input := `0x`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		if items[0].typ != itemError {
+			t.Error()
+		}
+	})
+
+	t.Run("Emits reverse operator", func(t *testing.T) {
+		input := `::A`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		if items[0].typ != itemReverse {
+			t.Error()
+		}
+		if items[1].typ != itemSymbol {
+			t.Error()
+		}
+	})
+
+	t.Run("Emits invert operators", func(t *testing.T) {
+		input := `!x ~y`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemBang, itemX, itemTilde, itemY, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+	})
+
+	t.Run("Emits shift operators", func(t *testing.T) {
+		input := `1 << 2 >> 3`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		if items[1].typ != itemShiftLeft {
+			t.Error()
+		}
+		if items[3].typ != itemShiftRight {
+			t.Error()
+		}
+	})
+
+	t.Run("Emits operand keywords followed by punctuation", func(t *testing.T) {
+		input := `pins, x, y,null isr,osr pindirs,pc exec,status pin pins_base`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemPins, itemComma, itemX, itemComma, itemY, itemComma, itemNull, itemISR, itemComma,
+			itemOSR, itemPindirs, itemComma, itemPC, itemExec, itemComma, itemStatus, itemPin, itemSymbol, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+	})
+
+	t.Run("Emits push and pull modifiers", func(t *testing.T) {
+		input := `iffull noblock ifempty block blocking`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemIfFull, itemNoBlock, itemIfEmpty, itemBlock, itemSymbol, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+	})
+
+	t.Run("Emits irq modifiers", func(t *testing.T) {
+		input := `irq clear nowait set wait clearing`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemInstrIRQ, itemClear, itemNoWait, itemInstrSET, itemInstrWAIT, itemSymbol, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+	})
+
+	t.Run("Emits configuration directives", func(t *testing.T) {
+		input := `.in .out .set .fifo .mov_status rxfifo < 2 .clock_div 2.50 left right auto txrx tx rx txput txget putget`
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemDirIn, itemDirOut, itemDirSet, itemDirFifo, itemDirMovStatus, itemRxFifo, itemLess,
+			itemNumber, itemDirClockDiv, itemFloat, itemLeft, itemRight, itemAuto, itemTxRx, itemTx, itemRx, itemTxPut,
+			itemTxGet, itemPutGet, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+		if items[9].val != "2.50" {
+			t.Errorf("%v", items[9])
+		}
+	})
+
+	t.Run("Error if a float or a directive is malformed.", func(t *testing.T) {
+		for _, input := range []string{"1.5x", "1.2.3", "0x1.5", ".unknown"} {
+			_, itemsCh := lex("test", input)
+			var last lexItem
+			for item := range itemsCh {
+				last = item
+			}
+			if last.typ != itemError {
+				t.Errorf("%s: %v", input, last)
+			}
+		}
+	})
+
+	t.Run("Error if a rune is unknown.", func(t *testing.T) {
+		for _, input := range []string{":", "nop >", "# nop"} {
+			_, itemsCh := lex("test", input)
+			var last lexItem
+			for item := range itemsCh {
+				last = item
+			}
+			if last.typ != itemError {
+				t.Errorf("%s: %v", input, last)
+			}
+		}
+	})
+
+	t.Run("Emits keywords followed by a colon as labels.", func(t *testing.T) {
+		_, itemsCh := lex("test", "next: mov x, ::y")
+		items := make([]lexItem, 0)
+		for item := range itemsCh {
+			items = append(items, item)
+		}
+
+		want := []itemType{itemLabel, itemInstrMOV, itemX, itemComma, itemReverse, itemY, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%v", items)
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+	})
+
+	t.Run("Emits code block", func(t *testing.T) {
+		input := "nop\n% c-sdk {\n  x = 1; // %\n %}\nnop"
+
+		_, itemsCh := lex("test", input)
+		items := make([]lexItem, 0)
+		for {
+			item, ok := <-itemsCh
+			if !ok {
+				break
+			}
+			t.Logf("%#v\n", item)
+			items = append(items, item)
+		}
+		want := []itemType{itemInstrNOP, itemEOL, itemCodeBlock, itemEOL, itemInstrNOP, itemEOF}
+		if len(items) != len(want) {
+			t.Fatalf("%d != %d", len(items), len(want))
+		}
+		for i, typ := range want {
+			if items[i].typ != typ {
+				t.Errorf("%d: %v", i, items[i])
+			}
+		}
+		if items[2].val != "% c-sdk {\n  x = 1; // %\n %}" {
+			t.Errorf("%v", items[2])
+		}
+	})
+
+	t.Run("Emits strings", func(t *testing.T) {
+		_, itemsCh := lex("test", ".include \"pins.pio\"\n.include \"open\n")
+		items := make([]lexItem, 0)
+		for item := range itemsCh {
+			items = append(items, item)
+		}
+
+		if items[0].typ != itemDirInclude || items[1].typ != itemString || items[1].val != "\"pins.pio\"" {
+			t.Errorf("%v", items)
+		}
+		if items[4].typ != itemError {
+			t.Errorf("%v", items[4])
+		}
+	})
 
 	t.Run("Emits identifier", func(t *testing.T) {
 		input := `
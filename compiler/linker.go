@@ -0,0 +1,163 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MemorySize is the number of instruction slots of a PIO block, shared by all of its programs.
+const MemorySize = 32
+
+// Placement is the offset of a program in the instruction memory.
+type Placement struct {
+	Program *AstProgram
+	Offset  int
+}
+
+// Image is the instruction memory of a PIO block with the programs placed by Link.
+type Image struct {
+	// Words holds the relocated instructions, 0 in the free slots
+	Words [MemorySize]uint16
+	// Used has a bit set for each slot holding an instruction
+	Used uint32
+	// Placements are in the order of the programs given to Link
+	Placements []Placement
+	// Version is the highest PIO version required by the programs
+	Version int
+}
+
+// Link packs the programs into the instruction memory as `pio_add_program` does at runtime. The programs with
+// `.origin` are placed first at their origin, then the rest in order at the highest offset where they fit.
+// The `jmp` targets of each program are relocated by its offset.
+func Link(programs ...*AstProgram) (*Image, error) {
+	image := &Image{Placements: make([]Placement, len(programs))}
+
+	for i, program := range programs {
+		if program.Origin() < 0 {
+			continue
+		}
+		if err := image.place(i, program, program.Origin()); err != nil {
+			return nil, err
+		}
+	}
+	for i, program := range programs {
+		if program.Origin() >= 0 {
+			continue
+		}
+		offset := image.findOffset(len(program.assembler))
+		if offset < 0 {
+			return nil, fmt.Errorf("program `%s` of %d instructions does not fit in the free instruction memory",
+				program.name, len(program.assembler))
+		}
+		if err := image.place(i, program, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+// Relocate returns the instruction word of a program loaded at the offset: the target of `jmp` is moved by it.
+func Relocate(word uint16, offset int) uint16 {
+	if word&0xe000 != opcodeJMP {
+		return word
+	}
+
+	return word&^0x1f | (word+uint16(offset))&0x1f
+}
+
+// Placement returns the placement of the program of the name.
+func (im *Image) Placement(name string) (Placement, bool) {
+	for _, placement := range im.Placements {
+		if placement.Program.name == name {
+			return placement, true
+		}
+	}
+
+	return Placement{}, false
+}
+
+// Map renders the placement of the programs in the order of their offsets, with the free slots.
+func (im *Image) Map() string {
+	var b bytes.Buffer
+
+	for offset := 0; offset < MemorySize; {
+		placement, ok := im.at(offset)
+		if !ok {
+			end := offset
+			for end+1 < MemorySize && im.Used&(1<<(end+1)) == 0 {
+				end++
+			}
+			b.WriteString(fmt.Sprintf("%2d..%-2d  free\n", offset, end))
+			offset = end + 1
+			continue
+		}
+		length := len(placement.Program.assembler)
+		b.WriteString(fmt.Sprintf("%2d..%-2d  %s", offset, offset+length-1, placement.Program.name))
+		if placement.Program.Origin() >= 0 {
+			b.WriteString(" (.origin)")
+		}
+		b.WriteString("\n")
+		offset += length
+	}
+
+	return b.String()
+}
+
+// at returns the placement of the program starting at the offset.
+func (im *Image) at(offset int) (Placement, bool) {
+	for _, placement := range im.Placements {
+		if placement.Program != nil && placement.Offset == offset && len(placement.Program.assembler) > 0 {
+			return placement, true
+		}
+	}
+
+	return Placement{}, false
+}
+
+// findOffset returns the highest offset of `length` free slots, -1 if there is none.
+// A program without instructions takes no slot and is placed at 0.
+func (im *Image) findOffset(length int) int {
+	if length == 0 {
+		return 0
+	}
+	mask := uint32(1)<<length - 1
+	if length == MemorySize {
+		mask = 0xffffffff
+	}
+	for offset := MemorySize - length; offset >= 0; offset-- {
+		if im.Used&(mask<<offset) == 0 {
+			return offset
+		}
+	}
+
+	return -1
+}
+
+func (im *Image) place(index int, program *AstProgram, offset int) error {
+	if offset+len(program.assembler) > MemorySize {
+		return fmt.Errorf("program `%s` of %d instructions does not fit at offset %d",
+			program.name, len(program.assembler), offset)
+	}
+	for i := range program.assembler {
+		if im.Used&(1<<(offset+i)) == 0 {
+			continue
+		}
+		for _, other := range im.Placements {
+			if other.Program != nil && offset+i >= other.Offset && offset+i < other.Offset+len(other.Program.assembler) {
+				return fmt.Errorf("program `%s` overlaps program `%s` at %d", program.name, other.Program.name, offset+i)
+			}
+		}
+	}
+
+	for i, word := range program.assembler {
+		im.Words[offset+i] = Relocate(word, offset)
+		im.Used |= 1 << (offset + i)
+	}
+	im.Placements[index] = Placement{Program: program, Offset: offset}
+	if program.version > im.Version {
+		im.Version = program.version
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Link(t *testing.T) {
+	compile := func(t *testing.T, source string) []*AstProgram {
+		ast, e := Compile(source, &Options{})
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		return ast.Programs()
+	}
+
+	t.Run("Places the programs at the top and relocates their jumps.", func(t *testing.T) {
+		programs := compile(t, `.program first
+loop:
+	set x, 1
+	jmp loop
+.program second
+	nop
+`)
+
+		image, err := Link(programs...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.Placements[0].Offset != 30 || image.Placements[1].Offset != 29 {
+			t.Errorf("%#v", image.Placements)
+		}
+		if image.Words[29] != 0xa042 || image.Words[30] != 0xe021 || image.Words[31] != 0x001e {
+			t.Errorf("%#v", image.Words)
+		}
+		if image.Used != 0xe0000000 {
+			t.Errorf("0x%08x", image.Used)
+		}
+	})
+
+	t.Run("Places the programs with `.origin` first.", func(t *testing.T) {
+		first := compile(t, ".program first\n\tnop\n\tnop\n")
+		second := compile(t, ".program second\n.origin 30\n\tnop\n")
+		third := compile(t, ".program third\n.origin 0\nloop:\n\tjmp loop\n")
+
+		image, err := Link(first[0], second[0], third[0])
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if placement, ok := image.Placement("first"); !ok || placement.Offset != 28 {
+			t.Errorf("%#v", placement)
+		}
+		if placement, ok := image.Placement("second"); !ok || placement.Offset != 30 {
+			t.Errorf("%#v", placement)
+		}
+		if image.Words[0] != 0x0000 || image.Used != 0x70000001 {
+			t.Errorf("0x%08x", image.Used)
+		}
+		if m := image.Map(); m != ` 0..0   third (.origin)
+ 1..27  free
+28..29  first
+30..30  second (.origin)
+31..31  free
+` {
+			t.Log(m)
+			t.Errorf("Map is different")
+		}
+	})
+
+	t.Run("Places the programs without instructions at 0.", func(t *testing.T) {
+		programs := compile(t, ".program empty\n.program full\n\tnop\n")
+
+		image, err := Link(programs...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.Placements[0].Offset != 0 || image.Placements[1].Offset != 31 || image.Used != 0x80000000 {
+			t.Errorf("%#v", image.Placements)
+		}
+		if image.Map() != " 0..30  free\n31..31  full\n" {
+			t.Errorf("%s", image.Map())
+		}
+	})
+
+	t.Run("Takes the highest PIO version.", func(t *testing.T) {
+		programs := compile(t, ".program first\n\tnop\n.program second\n.pio_version 1\n\tnop\n")
+
+		image, err := Link(programs...)
+
+		if err != nil || image.Version != 1 {
+			t.Errorf("%v %#v", err, image)
+		}
+	})
+
+	t.Run("Error if the programs overlap or overflow.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			message string
+		}{
+			{".program a\n.origin 1\nnop\nnop\n.program b\n.origin 2\nnop\n", "program `b` overlaps program `a` at 2"},
+			{".program a\n.origin 16\nnop\n.program b\n" + strings.Repeat("nop\n", 17),
+				"program `b` of 17 instructions does not fit in the free instruction memory"},
+		}
+
+		for _, tc := range cases {
+			_, err := Link(compile(t, tc.source)...)
+
+			if err == nil || err.Error() != tc.message {
+				t.Errorf("%s: %v", tc.source, err)
+			}
+		}
+	})
+}
+
+func Test_Relocate(t *testing.T) {
+	t.Run("Relocates only jumps.", func(t *testing.T) {
+		if got := Relocate(0x0025, 30); got != 0x0023 {
+			t.Errorf("0x%04x", got)
+		}
+		if got := Relocate(0xe021, 30); got != 0xe021 {
+			t.Errorf("0x%04x", got)
+		}
+	})
+}
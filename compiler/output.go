@@ -0,0 +1,62 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// output renders a compiled file for a target SDK or language, like the pioasm `-o` formats.
+type output interface {
+	write(b *bytes.Buffer, file *AstFile)
+}
+
+var outputs = map[string]output{
+	"c-sdk":  &cSdkOutput{},
+	"python": &pythonOutput{},
+}
+
+// Output renders the file in the given format, e.g. "c-sdk" or "python".
+func (a *AstFile) Output(format string) (string, error) {
+	o, ok := outputs[format]
+	if !ok {
+		return "", fmt.Errorf("unknown output format `%s`", format)
+	}
+
+	var b bytes.Buffer
+	o.write(&b, a)
+
+	return b.String(), nil
+}
+
+// writeBanner writes `message` framed with dashes using the comment prefix of the language.
+func writeBanner(b *bytes.Buffer, comment string, message string) {
+	dashes := strings.Repeat("-", len(message))
+	b.WriteString(fmt.Sprintf("%s %s %s\n", comment, dashes, comment))
+	b.WriteString(fmt.Sprintf("%s %s %s\n", comment, message, comment))
+	b.WriteString(fmt.Sprintf("%s %s %s\n", comment, dashes, comment))
+	b.WriteString("\n")
+}
+
+// publicDefines returns the defines which are exported by the outputs.
+func publicDefines(defines []*AstDefine) []*AstDefine {
+	result := make([]*AstDefine, 0)
+	for _, define := range defines {
+		if define.public {
+			result = append(result, define)
+		}
+	}
+
+	return result
+}
+
+// hasLangOpt tells if the program has the `.lang_opt` for the language.
+func hasLangOpt(program *AstProgram, lang string, name string) bool {
+	for _, langOpt := range program.langOpts {
+		if langOpt.lang == lang && langOpt.name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cSdkOutput renders a C header for the Raspberry Pi Pico SDK.
+type cSdkOutput struct{}
+
+func (o *cSdkOutput) write(b *bytes.Buffer, file *AstFile) {
+	writeBanner(b, "//", "This file is autogenerated by pioasm; do not edit!")
+	b.WriteString("#pragma once\n")
+	b.WriteString("\n")
+	b.WriteString("#if !PICO_NO_HARDWARE\n")
+	b.WriteString("#include \"hardware/pio.h\"\n")
+	b.WriteString("#endif\n")
+	b.WriteString("\n")
+
+	o.writeDefines(b, "", file.defines)
+	for _, codeBlock := range codeBlocks(file.codeBlocks, "c-sdk") {
+		b.WriteString(codeBlock.code + "\n")
+	}
+
+	for _, program := range file.programs {
+		o.writeProgram(b, program)
+	}
+}
+
+func (o *cSdkOutput) writeDefines(b *bytes.Buffer, prefix string, defines []*AstDefine) {
+	defines = publicDefines(defines)
+	for _, define := range defines {
+		b.WriteString(fmt.Sprintf("#define %s%s %d\n", prefix, define.name, define.value))
+	}
+	if len(defines) > 0 {
+		b.WriteString("\n")
+	}
+}
+
+// writeLabels writes the offsets of the public labels.
+func (o *cSdkOutput) writeLabels(b *bytes.Buffer, program *AstProgram) {
+	public := 0
+	for _, label := range program.labels {
+		if label.public {
+			b.WriteString(fmt.Sprintf("#define %s_offset_%s %du\n", program.name, label.name, label.index))
+			public++
+		}
+	}
+	if public > 0 {
+		b.WriteString("\n")
+	}
+}
+
+func (o *cSdkOutput) writeProgram(b *bytes.Buffer, program *AstProgram) {
+	name := program.name
+	writeBanner(b, "//", name)
+	b.WriteString(fmt.Sprintf("#define %s_wrap_target %d\n", name, program.wrapTarget()))
+	b.WriteString(fmt.Sprintf("#define %s_wrap %d\n", name, program.wrap()))
+	b.WriteString(fmt.Sprintf("#define %s_pio_version %d\n", name, program.version))
+	b.WriteString("\n")
+	o.writeDefines(b, name+"_", program.defines)
+	o.writeLabels(b, program)
+
+	b.WriteString(fmt.Sprintf("static const uint16_t %s_program_instructions[] = {\n", name))
+	for i, word := range program.assembler {
+		if i == program.wrapTarget() {
+			b.WriteString("            //     .wrap_target\n")
+		}
+		b.WriteString(fmt.Sprintf("    0x%04x, // %2d: %s\n", word, i, disassemble(word, program.sideSet)))
+		if i == program.wrap() {
+			b.WriteString("            //     .wrap\n")
+		}
+	}
+	b.WriteString("};\n")
+	b.WriteString("\n")
+
+	b.WriteString("#if !PICO_NO_HARDWARE\n")
+	b.WriteString(fmt.Sprintf("static const struct pio_program %s_program = {\n", name))
+	b.WriteString(fmt.Sprintf("    .instructions = %s_program_instructions,\n", name))
+	b.WriteString(fmt.Sprintf("    .length = %d,\n", len(program.assembler)))
+	b.WriteString(fmt.Sprintf("    .origin = %d,\n", program.Origin()))
+	b.WriteString(fmt.Sprintf("    .pio_version = %s_pio_version,\n", name))
+	b.WriteString("};\n")
+	b.WriteString("\n")
+	b.WriteString(fmt.Sprintf("static inline pio_sm_config %s_program_get_default_config(uint offset) {\n", name))
+	b.WriteString("    pio_sm_config c = pio_get_default_sm_config();\n")
+	b.WriteString(fmt.Sprintf("    sm_config_set_wrap(&c, offset + %s_wrap_target, offset + %s_wrap);\n", name, name))
+	if sideSet := program.sideSet; sideSet != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_sideset(&c, %d, %t, %t);\n",
+			sideSet.bitsIncludingOpt(), sideSet.optional, sideSet.pindirs))
+	}
+	o.writeConfig(b, program)
+	b.WriteString("    return c;\n")
+	b.WriteString("}\n")
+	if blocks := codeBlocks(program.codeBlocks, "c-sdk"); len(blocks) > 0 {
+		for _, codeBlock := range blocks {
+			b.WriteString("\n" + codeBlock.code)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("#endif\n")
+	b.WriteString("\n")
+}
+
+var cSdkFifoJoins = map[itemType]string{
+	itemTxRx:   "PIO_FIFO_JOIN_NONE",
+	itemTx:     "PIO_FIFO_JOIN_TX",
+	itemRx:     "PIO_FIFO_JOIN_RX",
+	itemTxPut:  "PIO_FIFO_JOIN_TXPUT",
+	itemTxGet:  "PIO_FIFO_JOIN_TXGET",
+	itemPutGet: "PIO_FIFO_JOIN_PUTGET",
+}
+
+var cSdkMovStatuses = map[itemType]string{
+	itemTxFifo:   "STATUS_TX_LESSTHAN",
+	itemRxFifo:   "STATUS_RX_LESSTHAN",
+	itemInstrIRQ: "STATUS_IRQ_SET",
+}
+
+// writeConfig writes the calls configuring the state machine as set by the directives of the program.
+func (o *cSdkOutput) writeConfig(b *bytes.Buffer, program *AstProgram) {
+	if in := program.in; in != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_in_pin_count(&c, %d);\n", in.pins))
+		b.WriteString(fmt.Sprintf("    sm_config_set_in_shift(&c, %t, %t, %d);\n", in.shiftRight(), in.auto, in.bits))
+	}
+	if out := program.out; out != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_out_pin_count(&c, %d);\n", out.pins))
+		b.WriteString(fmt.Sprintf("    sm_config_set_out_shift(&c, %t, %t, %d);\n", out.shiftRight(), out.auto, out.bits))
+	}
+	if set := program.set; set != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_set_pin_count(&c, %d);\n", set.pins))
+	}
+	if fifo := program.fifo; fifo != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_fifo_join(&c, %s);\n", cSdkFifoJoins[fifo.mode.typ]))
+	}
+	if movStatus := program.movStatus; movStatus != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_mov_status(&c, %s, %d);\n",
+			cSdkMovStatuses[movStatus.source], movStatus.value))
+	}
+	if clockDiv := program.clockDiv; clockDiv != nil {
+		b.WriteString(fmt.Sprintf("    sm_config_set_clkdiv(&c, %s);\n", cFloat(clockDiv.divider)))
+	}
+}
+
+// cFloat renders the value as a C float literal.
+func cFloat(value float64) string {
+	result := strconv.FormatFloat(value, 'f', -1, 64)
+	if !strings.Contains(result, ".") {
+		result += ".0"
+	}
+
+	return result + "f"
+}
@@ -0,0 +1,367 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Output_CSdk(t *testing.T) {
+	t.Run("Exports only public defines.", func(t *testing.T) {
+		source := `
+.define public GLOBAL 1 + 2
+.define PRIVATE 2
+
+.program ws2812
+.define public T1 2
+.define T2 5
+.define public T3 T1 + 1
+	wait 1 gpio T2
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+#if !PICO_NO_HARDWARE
+#include "hardware/pio.h"
+#endif
+
+#define GLOBAL 3
+
+// ------ //
+// ws2812 //
+// ------ //
+
+#define ws2812_wrap_target 0
+#define ws2812_wrap 0
+#define ws2812_pio_version 0
+
+#define ws2812_T1 2
+#define ws2812_T3 3
+
+static const uint16_t ws2812_program_instructions[] = {
+            //     .wrap_target
+    0x2085, //  0: wait   1 gpio 5
+            //     .wrap
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program ws2812_program = {
+    .instructions = ws2812_program_instructions,
+    .length = 1,
+    .origin = -1,
+    .pio_version = ws2812_pio_version,
+};
+
+static inline pio_sm_config ws2812_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + ws2812_wrap_target, offset + ws2812_wrap);
+    return c;
+}
+#endif
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports instructions with side-set.", func(t *testing.T) {
+		source := `
+.program waiter
+.side_set 2 opt
+	wait 0 pin 3 side 2 [1]
+	wait 1 irq 4 rel [3]
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+#if !PICO_NO_HARDWARE
+#include "hardware/pio.h"
+#endif
+
+// ------ //
+// waiter //
+// ------ //
+
+#define waiter_wrap_target 0
+#define waiter_wrap 1
+#define waiter_pio_version 0
+
+static const uint16_t waiter_program_instructions[] = {
+            //     .wrap_target
+    0x3923, //  0: wait   0 pin 3         side 2 [1]
+    0x23d4, //  1: wait   1 irq 4 rel     [3]
+            //     .wrap
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program waiter_program = {
+    .instructions = waiter_program_instructions,
+    .length = 2,
+    .origin = -1,
+    .pio_version = waiter_pio_version,
+};
+
+static inline pio_sm_config waiter_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + waiter_wrap_target, offset + waiter_wrap);
+    sm_config_set_sideset(&c, 3, true, false);
+    return c;
+}
+#endif
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports the wrap and the state machine configuration.", func(t *testing.T) {
+		source := `
+.program uart
+.in 32 left auto 8
+.out 1 right
+.set 2
+.fifo rx
+.mov_status rxfifo < 1
+.clock_div 2.5
+	set x, 3
+.wrap_target
+	in pins, 1
+.wrap
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+#if !PICO_NO_HARDWARE
+#include "hardware/pio.h"
+#endif
+
+// ---- //
+// uart //
+// ---- //
+
+#define uart_wrap_target 1
+#define uart_wrap 1
+#define uart_pio_version 0
+
+static const uint16_t uart_program_instructions[] = {
+    0xe023, //  0: set    x, 3
+            //     .wrap_target
+    0x4001, //  1: in     pins, 1
+            //     .wrap
+    0xa042, //  2: nop
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program uart_program = {
+    .instructions = uart_program_instructions,
+    .length = 3,
+    .origin = -1,
+    .pio_version = uart_pio_version,
+};
+
+static inline pio_sm_config uart_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + uart_wrap_target, offset + uart_wrap);
+    sm_config_set_in_pin_count(&c, 32);
+    sm_config_set_in_shift(&c, false, true, 8);
+    sm_config_set_out_pin_count(&c, 1);
+    sm_config_set_out_shift(&c, true, false, 32);
+    sm_config_set_set_pin_count(&c, 2);
+    sm_config_set_fifo_join(&c, PIO_FIFO_JOIN_RX);
+    sm_config_set_mov_status(&c, STATUS_RX_LESSTHAN, 1);
+    sm_config_set_clkdiv(&c, 2.5f);
+    return c;
+}
+#endif
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Renders the clock divider as a float.", func(t *testing.T) {
+		cases := []struct {
+			value float64
+			want  string
+		}{
+			{1, "1.0f"},
+			{2.5, "2.5f"},
+			{65536, "65536.0f"},
+		}
+
+		for _, tc := range cases {
+			if got := cFloat(tc.value); got != tc.want {
+				t.Errorf("%v: %s != %s", tc.value, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("Exports the c-sdk code blocks.", func(t *testing.T) {
+		source := `
+% c-sdk {
+#include "hardware/clocks.h"
+%}
+.program blink
+	nop
+% c-sdk {
+static inline void blink_program_init(PIO pio, uint sm) {
+}
+%}
+% python {
+print("ignored")
+%}
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `// -------------------------------------------------- //
+// This file is autogenerated by pioasm; do not edit! //
+// -------------------------------------------------- //
+
+#pragma once
+
+#if !PICO_NO_HARDWARE
+#include "hardware/pio.h"
+#endif
+
+#include "hardware/clocks.h"
+
+// ----- //
+// blink //
+// ----- //
+
+#define blink_wrap_target 0
+#define blink_wrap 0
+#define blink_pio_version 0
+
+static const uint16_t blink_program_instructions[] = {
+            //     .wrap_target
+    0xa042, //  0: nop
+            //     .wrap
+};
+
+#if !PICO_NO_HARDWARE
+static const struct pio_program blink_program = {
+    .instructions = blink_program_instructions,
+    .length = 1,
+    .origin = -1,
+    .pio_version = blink_pio_version,
+};
+
+static inline pio_sm_config blink_program_get_default_config(uint offset) {
+    pio_sm_config c = pio_get_default_sm_config();
+    sm_config_set_wrap(&c, offset + blink_wrap_target, offset + blink_wrap);
+    return c;
+}
+
+static inline void blink_program_init(PIO pio, uint sm) {
+}
+
+#endif
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports the offsets of public labels.", func(t *testing.T) {
+		source := `
+.program test
+start:
+	jmp end
+public loop: set x, 1
+	jmp x--, loop
+end:
+	jmp start
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "\n#define test_offset_loop 1u\n") {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+		if strings.Contains(out, "test_offset_start") {
+			t.Errorf("Private label exported")
+		}
+	})
+	t.Run("Exports the origin.", func(t *testing.T) {
+		ast, e := Compile(".program test\n.origin 4\n\tnop\n", &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("c-sdk")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "\n    .origin = 4,\n") {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+}
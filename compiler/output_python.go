@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pythonOutput renders MicroPython code using the `rp2` module.
+type pythonOutput struct{}
+
+func (o *pythonOutput) write(b *bytes.Buffer, file *AstFile) {
+	writeBanner(b, "#", "This file is autogenerated by pioasm; do not edit!")
+	b.WriteString("import rp2\n")
+	b.WriteString("from machine import Pin\n")
+	b.WriteString("\n")
+
+	o.writeDefines(b, "", file.defines)
+	for _, codeBlock := range codeBlocks(file.codeBlocks, "python") {
+		b.WriteString(codeBlock.code + "\n")
+	}
+
+	for _, program := range file.programs {
+		writeBanner(b, "#", program.name)
+		o.writeDefines(b, program.name+"_", program.defines)
+
+		o.writeProgram(b, program)
+		for _, codeBlock := range codeBlocks(program.codeBlocks, "python") {
+			b.WriteString(codeBlock.code + "\n")
+		}
+	}
+}
+
+func (o *pythonOutput) writeProgram(b *bytes.Buffer, program *AstProgram) {
+	b.WriteString(fmt.Sprintf("@rp2.asm_pio(%s)\n", strings.Join(o.decoratorArgs(program), ", ")))
+	b.WriteString(fmt.Sprintf("def %s():\n", program.name))
+	if len(program.assembler) == 0 {
+		b.WriteString("    pass\n")
+	}
+	targets := jmpTargets(program.assembler)
+	for i, word := range program.assembler {
+		if targets[i] {
+			b.WriteString(fmt.Sprintf("    label(\"%d\")\n", i))
+		}
+		if i == program.wrapTarget() {
+			b.WriteString("    wrap_target()\n")
+		}
+		b.WriteString(fmt.Sprintf("    %s\n", disassemblePython(word, program.sideSet)))
+		if i == program.wrap() {
+			b.WriteString("    wrap()\n")
+		}
+	}
+	b.WriteString("\n")
+}
+
+func (o *pythonOutput) writeDefines(b *bytes.Buffer, prefix string, defines []*AstDefine) {
+	defines = publicDefines(defines)
+	for _, define := range defines {
+		b.WriteString(fmt.Sprintf("%s%s = %d\n", prefix, define.name, define.value))
+	}
+	if len(defines) > 0 {
+		b.WriteString("\n")
+	}
+}
+
+var pythonFifoJoins = map[itemType]string{
+	itemTxRx: "rp2.PIO.JOIN_NONE",
+	itemTx:   "rp2.PIO.JOIN_TX",
+	itemRx:   "rp2.PIO.JOIN_RX",
+}
+
+// decoratorArgs returns the keyword arguments of `rp2.asm_pio` set by the directives of the program.
+// The pin counts other than the side-set one, the joins of PIO version 1, `.mov_status` and `.clock_div` have no equivalent.
+// The python options of `.lang_opt` come last and take precedence over the directives.
+func (o *pythonOutput) decoratorArgs(program *AstProgram) []string {
+	result := make([]string, 0)
+	for _, arg := range o.directiveArgs(program) {
+		if !hasLangOpt(program, "python", arg[:strings.Index(arg, "=")]) {
+			result = append(result, arg)
+		}
+	}
+	for _, langOpt := range program.langOpts {
+		if langOpt.lang == "python" {
+			result = append(result, fmt.Sprintf("%s=%s", langOpt.name, langOpt.value))
+		}
+	}
+
+	return result
+}
+
+func (o *pythonOutput) directiveArgs(program *AstProgram) []string {
+	result := make([]string, 0)
+	if sideSet := program.sideSet; sideSet != nil {
+		result = append(result, fmt.Sprintf("sideset_init=(rp2.PIO.OUT_LOW,)*%d", sideSet.bits))
+	}
+	if in := program.in; in != nil {
+		result = append(result, o.shiftArgs(in, "in_shiftdir", "autopush", "push_thresh")...)
+	}
+	if out := program.out; out != nil {
+		result = append(result, o.shiftArgs(out, "out_shiftdir", "autopull", "pull_thresh")...)
+	}
+	if fifo := program.fifo; fifo != nil {
+		if join, ok := pythonFifoJoins[fifo.mode.typ]; ok {
+			result = append(result, "fifo_join="+join)
+		}
+	}
+
+	return result
+}
+
+func (o *pythonOutput) shiftArgs(config *AstShiftConfig, shiftDir string, auto string, threshold string) []string {
+	result := make([]string, 0)
+	switch config.direction {
+	case itemLeft:
+		result = append(result, shiftDir+"=rp2.PIO.SHIFT_LEFT")
+	case itemRight:
+		result = append(result, shiftDir+"=rp2.PIO.SHIFT_RIGHT")
+	}
+	if config.auto {
+		result = append(result, auto+"=True")
+	}
+	if config.threshold != nil {
+		result = append(result, fmt.Sprintf("%s=%d", threshold, config.bits))
+	}
+
+	return result
+}
@@ -0,0 +1,254 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Output_Python(t *testing.T) {
+	t.Run("Exports only public defines.", func(t *testing.T) {
+		source := `
+.define public GLOBAL 1 + 2
+.define PRIVATE 2
+
+.program ws2812
+.define public T1 2
+.define T2 5
+.define public T3 T1 + 1
+	wait 1 gpio T2
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `# -------------------------------------------------- #
+# This file is autogenerated by pioasm; do not edit! #
+# -------------------------------------------------- #
+
+import rp2
+from machine import Pin
+
+GLOBAL = 3
+
+# ------ #
+# ws2812 #
+# ------ #
+
+ws2812_T1 = 2
+ws2812_T3 = 3
+
+@rp2.asm_pio()
+def ws2812():
+    wrap_target()
+    wait(1, gpio, 5)
+    wrap()
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports instructions with side-set.", func(t *testing.T) {
+		source := `
+.program waiter
+.side_set 2 opt
+	wait 0 pin 3 side 2 [1]
+	wait 1 irq 4 rel [3]
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `# -------------------------------------------------- #
+# This file is autogenerated by pioasm; do not edit! #
+# -------------------------------------------------- #
+
+import rp2
+from machine import Pin
+
+# ------ #
+# waiter #
+# ------ #
+
+@rp2.asm_pio(sideset_init=(rp2.PIO.OUT_LOW,)*2)
+def waiter():
+    wrap_target()
+    wait(0, pin, 3).side(2) [1]
+    wait(1, irq, rel(4)) [3]
+    wrap()
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports the wrap and the state machine configuration.", func(t *testing.T) {
+		source := `
+.program uart
+.in 32 left auto 8
+.out 1
+.fifo rx
+.clock_div 2.5
+	set x, 3
+.wrap_target
+	in pins, 1
+.wrap
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `# -------------------------------------------------- #
+# This file is autogenerated by pioasm; do not edit! #
+# -------------------------------------------------- #
+
+import rp2
+from machine import Pin
+
+# ---- #
+# uart #
+# ---- #
+
+@rp2.asm_pio(in_shiftdir=rp2.PIO.SHIFT_LEFT, autopush=True, push_thresh=8, fifo_join=rp2.PIO.JOIN_RX)
+def uart():
+    set(x, 3)
+    wrap_target()
+    in_(pins, 1)
+    wrap()
+    nop()
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Passes the python language options to the decorator.", func(t *testing.T) {
+		source := `
+.program blink
+.out 1 left
+.lang_opt python set_init = rp2.PIO.OUT_LOW
+.lang_opt python out_shiftdir = rp2.PIO.SHIFT_RIGHT
+.lang_opt c-sdk set_init = 1
+	nop
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "\n@rp2.asm_pio(set_init=rp2.PIO.OUT_LOW, out_shiftdir=rp2.PIO.SHIFT_RIGHT)\n") {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Exports the python code blocks.", func(t *testing.T) {
+		source := `
+% python {
+from time import sleep
+%}
+.program blink
+	nop
+% python {
+sm = rp2.StateMachine(0, blink)
+%}
+% c-sdk {
+int ignored;
+%}
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != `# -------------------------------------------------- #
+# This file is autogenerated by pioasm; do not edit! #
+# -------------------------------------------------- #
+
+import rp2
+from machine import Pin
+
+from time import sleep
+
+# ----- #
+# blink #
+# ----- #
+
+@rp2.asm_pio()
+def blink():
+    wrap_target()
+    nop()
+    wrap()
+
+sm = rp2.StateMachine(0, blink)
+
+` {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+
+	t.Run("Labels the targets of jumps.", func(t *testing.T) {
+		source := `
+.program test
+start:
+	jmp end
+public loop: set x, 1
+	jmp x--, loop
+end:
+	jmp start
+`
+		ast, e := Compile(source, &Options{})
+
+		if e != nil {
+			t.Fatalf("%#v", e)
+		}
+
+		out, err := ast.Output("python")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "\n    label(\"0\")\n    wrap_target()\n    jmp(\"3\")\n    label(\"1\")\n    set(x, 1)\n    jmp(x_dec, \"1\")\n    label(\"3\")\n    jmp(\"0\")\n") {
+			t.Logf(out)
+			t.Errorf("Output is different")
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package compiler
+
+import "testing"
+
+func Test_Output(t *testing.T) {
+	t.Run("Error if output format is unknown.", func(t *testing.T) {
+		ast, _ := Compile(`.define A 1`, &Options{})
+
+		out, err := ast.Output("cobol")
+
+		if out != "" || err == nil || err.Error() != "unknown output format `cobol`" {
+			t.Errorf("%#v %#v", out, err)
+		}
+	})
+}
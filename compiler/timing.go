@@ -0,0 +1,278 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// PathTiming is the number of cycles of the paths from a label to the next one reached.
+type PathTiming struct {
+	From string
+	// To is empty when the paths end in a jump to an address computed at run time
+	To  string
+	Min int
+	Max int
+	// Blocking paths pass instructions which may stall, the stalls are not counted
+	Blocking bool
+	// Unbounded paths loop without passing a label or jump to a computed address, Max counts one iteration
+	Unbounded bool
+}
+
+// Rates returns how often the path can repeat per second for the system clock in Hz and the clock divider.
+// Sending a bit per path, these are the bit rates.
+func (p PathTiming) Rates(clock float64, divider float64) (min float64, max float64) {
+	min = clock / divider / float64(p.Max)
+	max = clock / divider / float64(p.Min)
+	if p.Blocking || p.Unbounded {
+		min = 0
+	}
+
+	return
+}
+
+// Timing is the static timing of a program.
+type Timing struct {
+	program *AstProgram
+	// Paths between the labels, the start and the wrap target
+	Paths []PathTiming
+	// Wrap is the iteration from the wrap target back to it, labels ignored
+	Wrap PathTiming
+}
+
+// pathCycles accumulates the paths to a point while walking the instructions.
+type pathCycles struct {
+	min      int
+	max      int
+	blocking bool
+}
+
+// unknownTarget stands for the address of `out pc`, `mov pc` and `exec`.
+const unknownTarget = -1
+
+// Timing counts the cycles of the paths between the labels of the program, with their delays.
+// The offset 0 and the wrap target are treated as labels named after their offset.
+func (a *AstProgram) Timing() *Timing {
+	points := map[int]string{}
+	for _, index := range []int{0, a.wrapTarget()} {
+		points[index] = strconv.Itoa(index)
+	}
+	for i := len(a.labels) - 1; i >= 0; i-- {
+		if label := a.labels[i]; label.index < len(a.assembler) {
+			points[label.index] = label.name
+		}
+	}
+
+	result := &Timing{program: a}
+	for from := 0; from < len(a.assembler); from++ {
+		if _, ok := points[from]; !ok {
+			continue
+		}
+		paths, unbounded := a.walk(from, points)
+		for _, to := range sortedTargets(paths) {
+			path := PathTiming{From: points[from], To: points[to], Min: paths[to].min, Max: paths[to].max,
+				Blocking: paths[to].blocking, Unbounded: unbounded || to == unknownTarget}
+			result.Paths = append(result.Paths, path)
+		}
+	}
+
+	if len(a.assembler) > 0 {
+		wrapTarget := a.wrapTarget()
+		paths, unbounded := a.walk(wrapTarget, map[int]string{wrapTarget: points[wrapTarget]})
+		result.Wrap = PathTiming{From: points[wrapTarget], Unbounded: true}
+		if path, ok := paths[wrapTarget]; ok {
+			result.Wrap = PathTiming{From: points[wrapTarget], To: points[wrapTarget], Min: path.min, Max: path.max,
+				Blocking: path.blocking, Unbounded: unbounded || paths[unknownTarget] != nil}
+		}
+	}
+
+	return result
+}
+
+// walk returns the cycles of the paths from the instruction to the points they reach first, by point.
+// It tells whether a loop doesn't pass a point, which isn't counted.
+func (a *AstProgram) walk(from int, points map[int]string) (map[int]*pathCycles, bool) {
+	memo := map[int]map[int]*pathCycles{}
+	visiting := map[int]bool{}
+	loops := false
+
+	var visit func(index int) map[int]*pathCycles
+	visit = func(index int) map[int]*pathCycles {
+		if paths, ok := memo[index]; ok {
+			return paths
+		}
+		visiting[index] = true
+		word := a.assembler[index]
+		_, delay := splitDelaySideSet(word, a.sideSet)
+		cycles := 1 + delay
+		blocking := a.blocking(word)
+
+		paths := map[int]*pathCycles{}
+		successors, known := a.successors(index)
+		if !known {
+			paths[unknownTarget] = &pathCycles{}
+		}
+		for _, next := range successors {
+			if _, ok := points[next]; ok {
+				merge(paths, next, &pathCycles{})
+				continue
+			}
+			if visiting[next] {
+				loops = true
+				continue
+			}
+			for to, path := range visit(next) {
+				merge(paths, to, path)
+			}
+		}
+		// The paths of the successors are shared so they are copied with the cycles of the instruction
+		result := make(map[int]*pathCycles, len(paths))
+		for to, path := range paths {
+			result[to] = &pathCycles{min: path.min + cycles, max: path.max + cycles, blocking: path.blocking || blocking}
+		}
+
+		visiting[index] = false
+		memo[index] = result
+		return result
+	}
+
+	return visit(from), loops
+}
+
+func merge(paths map[int]*pathCycles, to int, path *pathCycles) {
+	current, ok := paths[to]
+	if !ok {
+		paths[to] = &pathCycles{min: path.min, max: path.max, blocking: path.blocking}
+		return
+	}
+	if path.min < current.min {
+		current.min = path.min
+	}
+	if path.max > current.max {
+		current.max = path.max
+	}
+	current.blocking = current.blocking || path.blocking
+}
+
+// successors returns the instructions which may follow the one at the index. It tells false if the
+// instruction jumps to an address computed at run time.
+func (a *AstProgram) successors(index int) ([]int, bool) {
+	word := a.assembler[index]
+	next := index + 1
+	if index == a.wrap() {
+		next = a.wrapTarget()
+	}
+	arg1 := word >> 5 & 0x7
+
+	switch word & 0xe000 {
+	case opcodeJMP:
+		target := int(word & 0x1f)
+		if jmpCondition(arg1) == jmpAlways {
+			return []int{target}, true
+		}
+		if target == next {
+			return []int{next}, true
+		}
+		return []int{target, next}, true
+	case opcodeOUT:
+		// `out pc` and `out exec`
+		if arg1 == 5 || arg1 == 7 {
+			return nil, false
+		}
+	case opcodeMOV:
+		// `mov exec` and `mov pc`
+		if arg1 == 4 || arg1 == 5 {
+			return nil, false
+		}
+	}
+
+	return []int{next}, true
+}
+
+// blocking tells whether the instruction may stall.
+func (a *AstProgram) blocking(word uint16) bool {
+	arg1 := word >> 5 & 0x7
+
+	switch word & 0xe000 {
+	case opcodeWAIT:
+		return true
+	case opcodeIN:
+		return a.in != nil && a.in.auto
+	case opcodeOUT:
+		return a.out != nil && a.out.auto
+	case opcodePUSHPULL:
+		// The `block` bit, `mov rxfifo` never stalls
+		return word&0x1f == 0 && arg1&0x1 != 0
+	case opcodeIRQ:
+		// `irq wait`
+		return arg1&0x3 == 0x1
+	}
+
+	return false
+}
+
+func sortedTargets(paths map[int]*pathCycles) []int {
+	result := make([]int, 0, len(paths))
+	for to := range paths {
+		result = append(result, to)
+	}
+	sort.Ints(result)
+
+	return result
+}
+
+// Report renders the timing with the rates for the system clock in Hz and the clock divider.
+func (t *Timing) Report(clock float64, divider float64) string {
+	var b bytes.Buffer
+
+	b.WriteString(fmt.Sprintf("%s at %s / %s\n", t.program.name, formatHz(clock), strconv.FormatFloat(divider, 'f', -1, 64)))
+	for _, path := range t.Paths {
+		b.WriteString(reportPath(fmt.Sprintf("%s -> %s", path.From, path.To), path, clock, divider))
+	}
+	if t.Wrap.To != "" {
+		b.WriteString(reportPath("wrap", t.Wrap, clock, divider))
+	}
+
+	return b.String()
+}
+
+func reportPath(name string, path PathTiming, clock float64, divider float64) string {
+	if path.To == "" {
+		return fmt.Sprintf("  %-24s computed jump\n", name)
+	}
+	cycles := strconv.Itoa(path.Min)
+	if path.Max != path.Min {
+		cycles += fmt.Sprintf("..%d", path.Max)
+	}
+	if path.Unbounded {
+		cycles += "+"
+	}
+	min, max := path.Rates(clock, divider)
+	rates := formatHz(max)
+	if min == 0 {
+		rates = "up to " + rates
+	} else if min != max {
+		rates = formatHz(min) + ".." + rates
+	}
+	result := fmt.Sprintf("  %-24s %s cycles, %s", name, cycles, rates)
+	if path.Blocking {
+		result += ", blocking"
+	}
+
+	return result + "\n"
+}
+
+func formatHz(hz float64) string {
+	for _, unit := range []struct {
+		scale float64
+		name  string
+	}{{1e9, "GHz"}, {1e6, "MHz"}, {1e3, "kHz"}} {
+		if hz >= unit.scale {
+			return strconv.FormatFloat(math.Round(hz/unit.scale*1e3)/1e3, 'f', -1, 64) + " " + unit.name
+		}
+	}
+
+	return strconv.FormatFloat(math.Round(hz*1e3)/1e3, 'f', -1, 64) + " Hz"
+}
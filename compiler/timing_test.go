@@ -0,0 +1,126 @@
+package compiler
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_Timing(t *testing.T) {
+	t.Run("Counts the cycles between labels with delays.", func(t *testing.T) {
+		source := `.program ws2812
+.side_set 1
+.wrap_target
+bitloop:
+	out x, 1       side 0 [2]
+	jmp !x do_zero side 1 [1]
+do_one:
+	jmp  bitloop   side 1 [4]
+do_zero:
+	nop            side 0 [4]
+.wrap
+`
+		ast, _ := Compile(source, &Options{})
+
+		timing := ast.Program("ws2812").Timing()
+
+		expected := "[{bitloop do_one 5 5 false false} {bitloop do_zero 5 5 false false} " +
+			"{do_one bitloop 5 5 false false} {do_zero bitloop 5 5 false false}]"
+		if got := fmt.Sprint(timing.Paths); got != expected {
+			t.Errorf("%s", got)
+		}
+		if got := fmt.Sprint(timing.Wrap); got != "{bitloop bitloop 10 10 false false}" {
+			t.Errorf("%s", got)
+		}
+	})
+
+	t.Run("Marks blocking paths.", func(t *testing.T) {
+		cases := []struct {
+			source   string
+			blocking bool
+		}{
+			{"wait 1 pin 0", true},
+			{"pull", true},
+			{"pull noblock", false},
+			{"push iffull block", true},
+			{"irq wait 1", true},
+			{"irq clear 1", false},
+			{"out x, 1", false},
+			{".out 1 right auto\nout x, 1", true},
+			{"in x, 1", false},
+			{".in 32 left auto\nin x, 1", true},
+		}
+
+		for _, tc := range cases {
+			ast, e := Compile(".program test\n"+tc.source+"\n", &Options{})
+			if e != nil {
+				t.Errorf("%s: %#v", tc.source, e)
+				continue
+			}
+
+			if wrap := ast.programs[0].Timing().Wrap; wrap.Blocking != tc.blocking || wrap.Min != 1 {
+				t.Errorf("%s: %+v", tc.source, wrap)
+			}
+		}
+	})
+
+	t.Run("Marks loops without labels and computed jumps as unbounded.", func(t *testing.T) {
+		source := `.program test
+	set x, 3
+	jmp x-- 1 [1]
+	nop [3]
+	out pc, 5
+`
+		ast, _ := Compile(source, &Options{})
+
+		timing := ast.Program("test").Timing()
+
+		if got := fmt.Sprint(timing.Paths); got != "[{0  8 8 false true}]" {
+			t.Errorf("%s", got)
+		}
+		if got := fmt.Sprint(timing.Wrap); got != "{0  0 0 false true}" {
+			t.Errorf("%s", got)
+		}
+	})
+
+	t.Run("Reports the rates.", func(t *testing.T) {
+		source := `.program uart_tx
+.side_set 1 opt
+	pull       side 1 [7]
+	set x, 7   side 0 [7]
+bitloop:
+	out pins, 1
+	jmp x-- bitloop [6]
+`
+		ast, _ := Compile(source, &Options{})
+
+		report := ast.Program("uart_tx").Timing().Report(125e6, 2.5)
+
+		if report != `uart_tx at 125 MHz / 2.5
+  0 -> bitloop             16 cycles, up to 3.125 MHz, blocking
+  bitloop -> 0             8 cycles, 6.25 MHz
+  bitloop -> bitloop       8 cycles, 6.25 MHz
+  wrap                     24+ cycles, up to 2.083 MHz, blocking
+` {
+			t.Log(report)
+			t.Errorf("Report is different")
+		}
+	})
+
+	t.Run("Computes the rates of paths.", func(t *testing.T) {
+		cases := []struct {
+			path PathTiming
+			min  float64
+			max  float64
+		}{
+			{PathTiming{Min: 8, Max: 8}, 1e6, 1e6},
+			{PathTiming{Min: 4, Max: 8}, 1e6, 2e6},
+			{PathTiming{Min: 8, Max: 8, Blocking: true}, 0, 1e6},
+		}
+
+		for _, tc := range cases {
+			if min, max := tc.path.Rates(16e6, 2); min != tc.min || max != tc.max {
+				t.Errorf("%+v: %v, %v", tc.path, min, max)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package emulator
+
+// fifo is the TX or the RX FIFO of a state machine.
+type fifo struct {
+	entries []uint32
+	depth   int
+}
+
+func (f *fifo) reset(depth int) {
+	f.entries = f.entries[:0]
+	f.depth = depth
+}
+
+func (f *fifo) level() int {
+	return len(f.entries)
+}
+
+func (f *fifo) empty() bool {
+	return len(f.entries) == 0
+}
+
+func (f *fifo) full() bool {
+	return len(f.entries) >= f.depth
+}
+
+// push appends the value unless the FIFO is full.
+func (f *fifo) push(value uint32) bool {
+	if f.full() {
+		return false
+	}
+	f.entries = append(f.entries, value)
+
+	return true
+}
+
+// pop removes the oldest value unless the FIFO is empty.
+func (f *fifo) pop() (uint32, bool) {
+	if f.empty() {
+		return 0, false
+	}
+	value := f.entries[0]
+	copy(f.entries, f.entries[1:])
+	f.entries = f.entries[:len(f.entries)-1]
+
+	return value, true
+}
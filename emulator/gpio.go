@@ -0,0 +1,68 @@
+package emulator
+
+import "math/bits"
+
+// GPIO is the bank of 32 pins shared by the state machines of a PIO block. A pin reads the level the
+// state machines drive if its output is enabled, otherwise the level set from the outside.
+type GPIO struct {
+	inputs  uint32
+	outputs uint32
+	enables uint32
+}
+
+// SetInput sets the level the pin is driven to from the outside.
+func (g *GPIO) SetInput(pin int, level bool) {
+	if level {
+		g.inputs |= 1 << pinIndex(pin)
+	} else {
+		g.inputs &^= 1 << pinIndex(pin)
+	}
+}
+
+// Level returns the level of the pin.
+func (g *GPIO) Level(pin int) bool {
+	return g.Levels()>>pinIndex(pin)&1 != 0
+}
+
+// Levels returns the levels of all the pins, bit n being the pin n.
+func (g *GPIO) Levels() uint32 {
+	return g.outputs&g.enables | g.inputs&^g.enables
+}
+
+// Outputs returns the levels driven by the state machines, whether the outputs are enabled or not.
+func (g *GPIO) Outputs() uint32 {
+	return g.outputs
+}
+
+func (g *GPIO) OutputEnables() uint32 {
+	return g.enables
+}
+
+// writeOutputs sets count pins from base, wrapping after the pin 31, to the low bits of value.
+func (g *GPIO) writeOutputs(base int, count int, value uint32) {
+	g.outputs = writePins(g.outputs, base, count, value)
+}
+
+// writeEnables enables the outputs of count pins from base, wrapping after the pin 31, by the low bits of value.
+func (g *GPIO) writeEnables(base int, count int, value uint32) {
+	g.enables = writePins(g.enables, base, count, value)
+}
+
+func writePins(pins uint32, base int, count int, value uint32) uint32 {
+	mask := bits.RotateLeft32(lowMask(count), pinIndex(base))
+
+	return pins&^mask | bits.RotateLeft32(value, pinIndex(base))&mask
+}
+
+// lowMask returns count low bits set.
+func lowMask(count int) uint32 {
+	if count >= 32 {
+		return 0xffffffff
+	}
+
+	return 1<<count - 1
+}
+
+func pinIndex(pin int) int {
+	return pin & 0x1f
+}
@@ -0,0 +1,26 @@
+package emulator
+
+import "testing"
+
+func Test_GPIO(t *testing.T) {
+	t.Run("Reads inputs unless the output is enabled.", func(t *testing.T) {
+		var gpio GPIO
+		gpio.SetInput(1, true)
+		gpio.SetInput(2, true)
+		gpio.writeOutputs(0, 32, 0x1)
+		gpio.writeEnables(0, 2, 0x3)
+
+		if got := gpio.Levels(); got != 0x5 {
+			t.Errorf("0x%x", got)
+		}
+	})
+
+	t.Run("Wraps pins after 31.", func(t *testing.T) {
+		var gpio GPIO
+		gpio.writeOutputs(30, 4, 0xf)
+
+		if got := gpio.Outputs(); got != 0xc0000003 {
+			t.Errorf("0x%x", got)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Package emulator executes assembled PIO programs cycle by cycle, so that they can be tested without hardware.
+package emulator
+
+import (
+	"fmt"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+const (
+	memorySize    = 32
+	stateMachines = 4
+)
+
+// PIO is a PIO block: the instruction memory and the GPIO shared by four state machines.
+type PIO struct {
+	// 0 for RP2040, 1 for RP2350
+	version int
+	memory  [memorySize]uint16
+	// Bit n is set when the slot n of the memory holds an instruction of a loaded program
+	used uint32
+	sms  [stateMachines]*StateMachine
+	gpio GPIO
+	// The 8 IRQ flags, 0..3 are routed to the system interrupts
+	irq    uint8
+	cycles uint64
+}
+
+// New returns a PIO block of the version with empty memory and the state machines disabled.
+func New(version int) *PIO {
+	p := &PIO{version: version}
+	for i := range p.sms {
+		p.sms[i] = newStateMachine(p, i)
+	}
+
+	return p
+}
+
+func (p *PIO) Version() int {
+	return p.version
+}
+
+func (p *PIO) GPIO() *GPIO {
+	return &p.gpio
+}
+
+// StateMachine returns the state machine 0..3.
+func (p *PIO) StateMachine(index int) *StateMachine {
+	return p.sms[index]
+}
+
+// Instruction returns the word at the address of the instruction memory.
+func (p *PIO) Instruction(address int) uint16 {
+	return p.memory[address%memorySize]
+}
+
+// IRQ returns the IRQ flags, bit n being the flag n.
+func (p *PIO) IRQ() uint8 {
+	return p.irq
+}
+
+// SetIRQ sets the flags of the mask from the system, as writing IRQ_FORCE does.
+func (p *PIO) SetIRQ(mask uint8) {
+	p.irq |= mask
+}
+
+// ClearIRQ clears the flags of the mask from the system, as `pio_interrupt_clear` does.
+func (p *PIO) ClearIRQ(mask uint8) {
+	p.irq &^= mask
+}
+
+// Cycles returns the number of system clock cycles stepped so far.
+func (p *PIO) Cycles() uint64 {
+	return p.cycles
+}
+
+// LoadProgram writes the program into the instruction memory at the offset and relocates its jumps,
+// as `pio_add_program_at_offset` does.
+func (p *PIO) LoadProgram(program *compiler.AstProgram, offset int) error {
+	if program.Version() > p.version {
+		return fmt.Errorf("program `%s` requires PIO version %d", program.Name(), program.Version())
+	}
+	if origin := program.Origin(); origin >= 0 && origin != offset {
+		return fmt.Errorf("program `%s` must be loaded at its origin %d", program.Name(), origin)
+	}
+	instructions := program.Instructions()
+	if offset < 0 || offset+len(instructions) > memorySize {
+		return fmt.Errorf("program `%s` of %d instructions does not fit at offset %d", program.Name(), len(instructions), offset)
+	}
+	for i := range instructions {
+		if p.used&(1<<(offset+i)) != 0 {
+			return fmt.Errorf("program `%s` overlaps the instruction memory at %d", program.Name(), offset+i)
+		}
+	}
+
+	for i, word := range instructions {
+		p.memory[offset+i] = compiler.Relocate(word, offset)
+		p.used |= 1 << (offset + i)
+	}
+
+	return nil
+}
+
+// LoadImage loads the programs at their offsets in the image made by compiler.Link.
+func (p *PIO) LoadImage(image *compiler.Image) error {
+	for _, placement := range image.Placements {
+		if err := p.LoadProgram(placement.Program, placement.Offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Step advances the PIO block by one system clock cycle. The enabled state machines step in lockstep unless
+// their clock divider holds them: they all see the pins and the IRQ flags as they were before the cycle.
+// A pin written by several state machines takes the value of the highest numbered one.
+// Every state machine steps even if one fails, the error of the lowest numbered one is returned.
+func (p *PIO) Step() error {
+	p.cycles++
+	var err error
+	for _, sm := range p.sms {
+		if !sm.enabled {
+			continue
+		}
+		if smErr := sm.step(); err == nil {
+			err = smErr
+		}
+	}
+	for _, sm := range p.sms {
+		sm.commit()
+	}
+
+	return err
+}
+
+// Run steps the PIO block by the number of system clock cycles or until an error.
+func (p *PIO) Run(cycles int) error {
+	for i := 0; i < cycles; i++ {
+		if err := p.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExecError is an instruction a state machine cannot execute.
+type ExecError struct {
+	SM      int
+	PC      int
+	Word    uint16
+	Message string
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("sm %d at %d, 0x%04x: %s", e.SM, e.PC, e.Word, e.Message)
+}
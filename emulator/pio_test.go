@@ -0,0 +1,259 @@
+package emulator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+func Test_LoadProgram(t *testing.T) {
+	compile := func(t *testing.T, source string) *compiler.AstProgram {
+		file, e := compiler.Compile(source, &compiler.Options{})
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+
+		return file.Programs()[0]
+	}
+
+	t.Run("Relocates jumps.", func(t *testing.T) {
+		program := compile(t, `.program test
+loop:
+set x, 1
+jmp !x, loop
+`)
+		pio := New(0)
+
+		if err := pio.LoadProgram(program, 30); err != nil {
+			t.Fatal(err)
+		}
+		if got := pio.Instruction(31); got != 0x003e {
+			t.Errorf("0x%04x", got)
+		}
+	})
+
+	t.Run("Errors.", func(t *testing.T) {
+		cases := []struct {
+			source  string
+			version int
+			offset  int
+			message string
+		}{
+			{".program test\nnop\nnop\n", 0, 31, "program `test` of 2 instructions does not fit at offset 31"},
+			{".program test\nnop\n", 0, -1, "program `test` of 1 instructions does not fit at offset -1"},
+			{".program test\nnop\nnop\n", 0, 3, "program `test` overlaps the instruction memory at 4"},
+			{".pio_version 1\n.program test\nnop\n", 0, 0, "program `test` requires PIO version 1"},
+			{".program test\n.origin 8\nnop\n", 0, 0, "program `test` must be loaded at its origin 8"},
+		}
+
+		for _, tc := range cases {
+			pio := New(tc.version)
+			// Occupies 4
+			_ = pio.LoadProgram(compile(t, ".program other\nnop\n"), 4)
+
+			err := pio.LoadProgram(compile(t, tc.source), tc.offset)
+			if err == nil || err.Error() != tc.message {
+				t.Errorf("%v", err)
+			}
+		}
+	})
+}
+
+func Test_LoadImage(t *testing.T) {
+	t.Run("Loads the programs at their placements.", func(t *testing.T) {
+		file, e := compiler.Compile(`.program first
+loop:
+	jmp loop
+.program second
+.origin 0
+	set x, 1
+`, &compiler.Options{})
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+		image, err := compiler.Link(file.Programs()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pio := New(0)
+
+		if err := pio.LoadImage(image); err != nil {
+			t.Fatal(err)
+		}
+		if pio.Instruction(0) != 0xe021 || pio.Instruction(31) != 0x001f {
+			t.Errorf("0x%04x 0x%04x", pio.Instruction(0), pio.Instruction(31))
+		}
+	})
+}
+
+// startAll loads the programs of the source one after the other and enables a state machine for each.
+func startAll(t *testing.T, source string, configure func(*Config)) *PIO {
+	t.Helper()
+	file, e := compiler.Compile(source, &compiler.Options{})
+	if e != nil {
+		t.Fatalf("%s", e.ToString())
+	}
+	pio := New(0)
+	offset := 0
+	for i, program := range file.Programs() {
+		if err := pio.LoadProgram(program, offset); err != nil {
+			t.Fatal(err)
+		}
+		config := NewConfig(program, offset)
+		if configure != nil {
+			configure(&config)
+		}
+		pio.StateMachine(i).Init(offset, config)
+		pio.StateMachine(i).SetEnabled(true)
+		offset += len(program.Instructions())
+	}
+
+	return pio
+}
+
+func Test_PIO(t *testing.T) {
+	t.Run("Synchronizes state machines with IRQ flags.", func(t *testing.T) {
+		pio := startAll(t, `.program waiter
+	irq wait 0
+	set x, 1
+.program releaser
+	wait 1 irq 0
+	set y, 1
+`, nil)
+		waiter, releaser := pio.StateMachine(0), pio.StateMachine(1)
+
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if pio.IRQ() != 0x1 || !waiter.Stalled() || !releaser.Stalled() {
+			t.Errorf("IRQ: 0x%x, waiter stalled: %t, releaser stalled: %t", pio.IRQ(), waiter.Stalled(), releaser.Stalled())
+		}
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if pio.IRQ() != 0 || !waiter.Stalled() || releaser.PC() != 3 {
+			t.Errorf("IRQ: 0x%x, waiter stalled: %t, releaser pc: %d", pio.IRQ(), waiter.Stalled(), releaser.PC())
+		}
+		if err := pio.Run(2); err != nil {
+			t.Fatal(err)
+		}
+		if waiter.X() != 1 || releaser.Y() != 1 {
+			t.Errorf("x: %d, y: %d", waiter.X(), releaser.Y())
+		}
+	})
+
+	t.Run("Steps every state machine if one fails.", func(t *testing.T) {
+		file, e := compiler.Compile(`.pio_version 1
+.program failing
+	mov rxfifo[y], isr
+.program setter
+	set x, 1
+`, &compiler.Options{})
+		if e != nil {
+			t.Fatalf("%s", e.ToString())
+		}
+		pio := New(1)
+		for i, program := range file.Programs() {
+			if err := pio.LoadProgram(program, i); err != nil {
+				t.Fatal(err)
+			}
+			pio.StateMachine(i).Init(i, NewConfig(program, i))
+			pio.StateMachine(i).SetEnabled(true)
+		}
+
+		err := pio.Step()
+		var execError *ExecError
+		if !errors.As(err, &execError) || execError.SM != 0 {
+			t.Errorf("%v", err)
+		}
+		if pio.StateMachine(1).X() != 1 {
+			t.Errorf("x: %d", pio.StateMachine(1).X())
+		}
+	})
+
+	t.Run("Indexes IRQ flags relative to the state machine.", func(t *testing.T) {
+		pio := startAll(t, `.program a
+	irq set 3 rel
+stop:
+	jmp stop
+.program b
+	irq set 3 rel
+stop:
+	jmp stop
+.program c
+	irq set 6 rel
+stop:
+	jmp stop
+.program d
+	wait 0 irq 4
+	irq clear 0 rel
+stop:
+	jmp stop
+`, nil)
+		pio.SetIRQ(0x10)
+
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if pio.IRQ() != 0x19 {
+			t.Errorf("IRQ: 0x%x", pio.IRQ())
+		}
+		pio.ClearIRQ(0x10)
+		if err := pio.Run(2); err != nil {
+			t.Fatal(err)
+		}
+		if pio.IRQ() != 0x01 {
+			t.Errorf("IRQ: 0x%x", pio.IRQ())
+		}
+	})
+
+	t.Run("Gives pins to the highest state machine and to the side-set.", func(t *testing.T) {
+		pio := startAll(t, `.program low
+	set pins, 1
+.program high
+.side_set 1 opt
+	set pins, 3 side 0
+.program higher
+	set pins, 0
+`, func(config *Config) { config.SetCount = 2 })
+		pio.StateMachine(2).SetConsecutivePindirs(0, 2, true)
+
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if got := pio.GPIO().Levels(); got != 0 {
+			t.Errorf("levels: 0x%x", got)
+		}
+		pio.StateMachine(2).SetEnabled(false)
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		// The side-set of the state machine 1 clears the pin 0 which it sets
+		if got := pio.GPIO().Levels(); got != 0x2 {
+			t.Errorf("levels: 0x%x", got)
+		}
+	})
+
+	t.Run("Reads the pins as they were before the cycle.", func(t *testing.T) {
+		pio := startAll(t, `.program writer
+	set pins, 1
+.program reader
+	mov x, pins
+`, func(config *Config) { config.SetCount = 1 })
+		pio.StateMachine(0).SetConsecutivePindirs(0, 1, true)
+
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if x := pio.StateMachine(1).X(); x != 0 {
+			t.Errorf("x: %d", x)
+		}
+		if err := pio.Run(1); err != nil {
+			t.Fatal(err)
+		}
+		if x := pio.StateMachine(1).X(); x != 1 {
+			t.Errorf("x: %d", x)
+		}
+	})
+}
@@ -0,0 +1,793 @@
+package emulator
+
+import (
+	"math/bits"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+const (
+	opcodeJMP      = 0x0000
+	opcodeWAIT     = 0x2000
+	opcodeIN       = 0x4000
+	opcodeOUT      = 0x6000
+	opcodePUSHPULL = 0x8000
+	opcodeMOV      = 0xa000
+	opcodeIRQ      = 0xc000
+	opcodeSET      = 0xe000
+)
+
+// Config is the configuration of a state machine: the one of the program and the pins it uses.
+// Zero counts and thresholds read as 32 and a clock divider below 1 as 1.
+type Config struct {
+	compiler.SmConfig
+	OutBase     int
+	SetBase     int
+	SideSetBase int
+	InBase      int
+	JmpPin      int
+}
+
+// NewConfig returns the default configuration of the program loaded at the offset, with the pins at 0.
+func NewConfig(program *compiler.AstProgram, offset int) Config {
+	config := Config{SmConfig: program.DefaultConfig()}
+	config.WrapTarget += offset
+	config.Wrap += offset
+
+	return config
+}
+
+// StateMachine is a state machine of a PIO block.
+type StateMachine struct {
+	pio     *PIO
+	index   int
+	config  Config
+	enabled bool
+	pc      int
+	x       uint32
+	y       uint32
+	isr     uint32
+	osr     uint32
+	// Bits shifted into the ISR and out of the OSR, an empty OSR counts 32
+	isrCount int
+	osrCount int
+	// Cycles left of the delay of the last instruction
+	delay   int
+	stalled bool
+	// The last cycle completed an instruction
+	completed bool
+	// Instruction executed instead of the one at pc, from `out exec`, `mov exec` or Exec
+	exec        uint16
+	execPending bool
+	tx          fifo
+	rx          fifo
+	// Storage registers replacing the RX FIFO in the put and get modes of `.fifo`
+	rxRegisters [4]uint32
+	// Clock divider in 1/256 and the system clock accumulated towards the next cycle
+	divider int
+	clock   int
+	// `irq wait` has set its flag and waits for it to be cleared
+	irqWaiting bool
+	// Set by the instruction being executed
+	jumped    bool
+	skipDelay bool
+	// Writes of the cycle, applied after those of the lower state machines
+	writes        []pinWrite
+	sideSetWrites []pinWrite
+	irqSet        uint8
+	irqClear      uint8
+}
+
+// pinWrite is a write of the outputs or the output enables of count pins from base.
+type pinWrite struct {
+	enables bool
+	base    int
+	count   int
+	value   uint32
+}
+
+func newStateMachine(pio *PIO, index int) *StateMachine {
+	sm := &StateMachine{pio: pio, index: index}
+	sm.Init(0, Config{SmConfig: compiler.SmConfig{Wrap: memorySize - 1}})
+
+	return sm
+}
+
+// Init disables the state machine, applies the configuration, clears the FIFOs and restarts it at pc,
+// as `pio_sm_init` does.
+func (sm *StateMachine) Init(pc int, config Config) {
+	if config.InCount == 0 {
+		config.InCount = 32
+	}
+	if config.PushThreshold == 0 {
+		config.PushThreshold = 32
+	}
+	if config.PullThreshold == 0 {
+		config.PullThreshold = 32
+	}
+	if config.ClockDiv < 1 {
+		config.ClockDiv = 1
+	}
+	sm.enabled = false
+	sm.config = config
+	sm.divider = int(config.ClockDiv * 256)
+	sm.clock = 0
+	sm.ClearFifos()
+	sm.Restart()
+	sm.pc = pc % memorySize
+}
+
+// Restart clears the shift counters, the delay, the stall and the pending `exec`. The registers keep their values.
+func (sm *StateMachine) Restart() {
+	sm.isrCount = 0
+	sm.osrCount = 32
+	sm.delay = 0
+	sm.stalled = false
+	sm.completed = false
+	sm.execPending = false
+	sm.irqWaiting = false
+}
+
+// ClearFifos empties the FIFOs and sizes them after the join mode.
+func (sm *StateMachine) ClearFifos() {
+	switch sm.config.FifoJoin {
+	case compiler.FifoJoinTx:
+		sm.tx.reset(8)
+		sm.rx.reset(0)
+	case compiler.FifoJoinRx:
+		sm.tx.reset(0)
+		sm.rx.reset(8)
+	case compiler.FifoJoinTxPut, compiler.FifoJoinTxGet:
+		sm.tx.reset(4)
+		sm.rx.reset(0)
+	case compiler.FifoJoinPutGet:
+		sm.tx.reset(0)
+		sm.rx.reset(0)
+	default:
+		sm.tx.reset(4)
+		sm.rx.reset(4)
+	}
+}
+
+func (sm *StateMachine) SetEnabled(enabled bool) {
+	sm.enabled = enabled
+}
+
+func (sm *StateMachine) Enabled() bool {
+	return sm.enabled
+}
+
+func (sm *StateMachine) Index() int {
+	return sm.index
+}
+
+func (sm *StateMachine) Config() Config {
+	return sm.config
+}
+
+func (sm *StateMachine) PC() int {
+	return sm.pc
+}
+
+func (sm *StateMachine) X() uint32 {
+	return sm.x
+}
+
+func (sm *StateMachine) Y() uint32 {
+	return sm.y
+}
+
+func (sm *StateMachine) ISR() uint32 {
+	return sm.isr
+}
+
+func (sm *StateMachine) OSR() uint32 {
+	return sm.osr
+}
+
+// ISRCount returns the number of bits shifted into the ISR.
+func (sm *StateMachine) ISRCount() int {
+	return sm.isrCount
+}
+
+// OSRCount returns the number of bits shifted out of the OSR.
+func (sm *StateMachine) OSRCount() int {
+	return sm.osrCount
+}
+
+// Delay returns the number of cycles left of the delay of the last instruction.
+func (sm *StateMachine) Delay() int {
+	return sm.delay
+}
+
+// Stalled tells whether the last instruction could not complete, e.g. waiting for a pin or a FIFO.
+func (sm *StateMachine) Stalled() bool {
+	return sm.stalled
+}
+
+// Completed tells whether the last cycle completed an instruction, which is false while it stalls or delays.
+func (sm *StateMachine) Completed() bool {
+	return sm.completed
+}
+
+// SetConsecutivePindirs sets the direction of count pins from base, as `pio_sm_set_consecutive_pindirs` does.
+func (sm *StateMachine) SetConsecutivePindirs(base int, count int, output bool) {
+	var value uint32
+	if output {
+		value = 0xffffffff
+	}
+	sm.pio.gpio.writeEnables(base, count, value)
+}
+
+// Put writes the word to the TX FIFO unless it is full.
+func (sm *StateMachine) Put(word uint32) bool {
+	return sm.tx.push(word)
+}
+
+// Get reads a word from the RX FIFO unless it is empty.
+func (sm *StateMachine) Get() (uint32, bool) {
+	return sm.rx.pop()
+}
+
+func (sm *StateMachine) TxLevel() int {
+	return sm.tx.level()
+}
+
+func (sm *StateMachine) RxLevel() int {
+	return sm.rx.level()
+}
+
+// RxFifoRegister returns the RX FIFO storage register 0..3 of the put and get modes of `.fifo`.
+func (sm *StateMachine) RxFifoRegister(index int) uint32 {
+	return sm.rxRegisters[index]
+}
+
+func (sm *StateMachine) SetRxFifoRegister(index int, value uint32) {
+	sm.rxRegisters[index] = value
+}
+
+// Exec executes the instruction right away, as writing SMx_INSTR does. An instruction which stalls stays
+// pending and completes in the following cycles of the enabled state machine.
+func (sm *StateMachine) Exec(word uint16) error {
+	sm.exec = word
+	sm.execPending = true
+	err := sm.run()
+	sm.commit()
+
+	return err
+}
+
+// step advances the state machine by one system clock cycle.
+func (sm *StateMachine) step() error {
+	sm.completed = false
+	sm.clock += 256
+	if sm.clock < sm.divider {
+		return nil
+	}
+	sm.clock -= sm.divider
+	if sm.delay > 0 {
+		sm.delay--
+		return nil
+	}
+
+	return sm.run()
+}
+
+// run executes the pending `exec` or the instruction at pc.
+func (sm *StateMachine) run() error {
+	word := sm.pio.memory[sm.pc]
+	executing := sm.execPending
+	if executing {
+		word = sm.exec
+		sm.execPending = false
+	}
+	sm.jumped = false
+	sm.skipDelay = false
+
+	// The side-set takes effect even if the instruction stalls
+	sideSet, delay := sm.splitDelaySideSet(word)
+	if sideSet >= 0 {
+		sm.sideSetWrites = append(sm.sideSetWrites, pinWrite{
+			enables: sm.config.SideSetPindirs,
+			base:    sm.config.SideSetBase,
+			count:   sideSetPins(sm.config),
+			value:   uint32(sideSet),
+		})
+	}
+
+	done, err := sm.execute(word)
+	if err != nil || !done {
+		if executing {
+			sm.exec = word
+			sm.execPending = true
+		}
+		sm.stalled = err == nil
+
+		return err
+	}
+	sm.stalled = false
+	sm.completed = true
+	if !sm.skipDelay {
+		sm.delay = delay
+	}
+	// An executed instruction doesn't advance pc unless it jumps
+	if !sm.jumped && !executing {
+		if sm.pc == sm.config.Wrap {
+			sm.pc = sm.config.WrapTarget
+		} else {
+			sm.pc = (sm.pc + 1) % memorySize
+		}
+	}
+
+	return nil
+}
+
+// splitDelaySideSet returns the side-set value, -1 if the instruction has none, and the delay.
+func (sm *StateMachine) splitDelaySideSet(word uint16) (sideSet int, delay int) {
+	field := uint32(word>>8) & 0x1f
+	sideSetBits := sm.config.SideSetCount
+	delayBits := 5 - sideSetBits
+	delay = int(field & lowMask(delayBits))
+	sideSet = -1
+	if sideSetBits > 0 && (!sm.config.SideSetOptional || field&0x10 != 0) {
+		if sm.config.SideSetOptional {
+			sideSetBits--
+		}
+		sideSet = int(field >> delayBits & lowMask(sideSetBits))
+	}
+
+	return
+}
+
+// execute performs the instruction and tells whether it completed or stalls.
+func (sm *StateMachine) execute(word uint16) (bool, error) {
+	arg1 := int(word >> 5 & 0x7)
+	arg2 := uint32(word & 0x1f)
+
+	switch word & 0xe000 {
+	case opcodeJMP:
+		if sm.jmpCondition(arg1) {
+			sm.jump(arg2)
+		}
+		return true, nil
+	case opcodeWAIT:
+		return sm.wait(word, arg1, arg2)
+	case opcodeIN:
+		return sm.in(word, arg1, bitCount(arg2))
+	case opcodeOUT:
+		return sm.out(arg1, bitCount(arg2)), nil
+	case opcodePUSHPULL:
+		if arg2 != 0 {
+			return sm.movRxFifo(word, arg1, arg2)
+		}
+		if arg1&0x4 != 0 {
+			return sm.pull(arg1&0x2 != 0, arg1&0x1 != 0), nil
+		}
+		return sm.push(arg1&0x2 != 0, arg1&0x1 != 0), nil
+	case opcodeMOV:
+		return sm.mov(word, arg1, arg2)
+	case opcodeIRQ:
+		return sm.irq(word, arg1, arg2)
+	default:
+		return sm.set(word, arg1, arg2)
+	}
+}
+
+func (sm *StateMachine) jmpCondition(condition int) bool {
+	switch condition {
+	case 0:
+		return true
+	case 1:
+		return sm.x == 0
+	case 2:
+		taken := sm.x != 0
+		sm.x--
+		return taken
+	case 3:
+		return sm.y == 0
+	case 4:
+		taken := sm.y != 0
+		sm.y--
+		return taken
+	case 5:
+		return sm.x != sm.y
+	case 6:
+		return sm.pio.gpio.Level(sm.config.JmpPin)
+	default:
+		return sm.osrCount < sm.config.PullThreshold
+	}
+}
+
+func (sm *StateMachine) wait(word uint16, arg1 int, arg2 uint32) (bool, error) {
+	var level bool
+	switch arg1 & 0x3 {
+	case 0:
+		level = sm.pio.gpio.Level(int(arg2))
+	case 1:
+		level = sm.pio.gpio.Level(sm.config.InBase + int(arg2))
+	case 2:
+		flag, err := sm.irqFlag(word, arg2)
+		if err != nil {
+			return false, err
+		}
+		level = sm.pio.irq&flag != 0
+		// `wait 1 irq` clears the flag it waited for
+		if level && arg1&0x4 != 0 {
+			sm.irqClear |= flag
+		}
+	default:
+		if sm.pio.version < 1 {
+			return false, sm.execError(word, "`wait jmppin` requires PIO version 1")
+		}
+		level = sm.pio.gpio.Level(sm.config.JmpPin + int(arg2))
+	}
+
+	return level == (arg1&0x4 != 0), nil
+}
+
+func (sm *StateMachine) in(word uint16, source int, count int) (bool, error) {
+	var data uint32
+	switch source {
+	case 0:
+		data = sm.inPins()
+	case 1:
+		data = sm.x
+	case 2:
+		data = sm.y
+	case 3:
+		data = 0
+	case 6:
+		data = sm.isr
+	case 7:
+		data = sm.osr
+	default:
+		return false, sm.execError(word, "reserved `in` source")
+	}
+
+	autopush := sm.config.Autopush && sm.isrCount+count >= sm.config.PushThreshold
+	if autopush && sm.rx.full() {
+		return false, nil
+	}
+	data &= lowMask(count)
+	if sm.config.InShiftRight {
+		sm.isr = sm.isr>>count | data<<(32-count)
+	} else {
+		sm.isr = sm.isr<<count | data
+	}
+	sm.isrCount = saturate(sm.isrCount + count)
+	if autopush {
+		sm.rx.push(sm.isr)
+		sm.isr = 0
+		sm.isrCount = 0
+	}
+
+	return true, nil
+}
+
+func (sm *StateMachine) out(destination int, count int) bool {
+	if sm.config.Autopull && sm.osrCount >= sm.config.PullThreshold && !sm.refill() {
+		return false
+	}
+
+	var data uint32
+	if sm.config.OutShiftRight {
+		data = sm.osr & lowMask(count)
+		sm.osr >>= count
+	} else {
+		data = sm.osr >> (32 - count)
+		sm.osr <<= count
+	}
+	sm.osrCount = saturate(sm.osrCount + count)
+
+	switch destination {
+	case 0:
+		sm.writePins(false, sm.config.OutBase, sm.config.OutCount, data)
+	case 1:
+		sm.x = data
+	case 2:
+		sm.y = data
+	case 4:
+		sm.writePins(true, sm.config.OutBase, sm.config.OutCount, data)
+	case 5:
+		sm.jump(data)
+	case 6:
+		sm.isr = data
+		sm.isrCount = count
+	case 7:
+		sm.execNext(uint16(data))
+	}
+
+	// Autopull refills the OSR as soon as the threshold is reached
+	if sm.config.Autopull && sm.osrCount >= sm.config.PullThreshold {
+		sm.refill()
+	}
+
+	return true
+}
+
+// refill pulls the OSR from the TX FIFO unless it is empty.
+func (sm *StateMachine) refill() bool {
+	value, ok := sm.tx.pop()
+	if ok {
+		sm.osr = value
+		sm.osrCount = 0
+	}
+
+	return ok
+}
+
+func (sm *StateMachine) push(ifFull bool, block bool) bool {
+	if ifFull && sm.isrCount < sm.config.PushThreshold {
+		return true
+	}
+	if !sm.rx.push(sm.isr) && block {
+		return false
+	}
+	sm.isr = 0
+	sm.isrCount = 0
+
+	return true
+}
+
+func (sm *StateMachine) pull(ifEmpty bool, block bool) bool {
+	// With autopull `pull` only waits for the OSR to be refilled
+	if (ifEmpty || sm.config.Autopull) && sm.osrCount < sm.config.PullThreshold {
+		return true
+	}
+	if sm.refill() {
+		return true
+	}
+	if block {
+		return false
+	}
+	sm.osr = sm.x
+	sm.osrCount = 0
+
+	return true
+}
+
+// movRxFifo performs `mov rxfifo[], isr` or `mov osr, rxfifo[]`, which share the encoding of `push` and `pull`.
+func (sm *StateMachine) movRxFifo(word uint16, arg1 int, arg2 uint32) (bool, error) {
+	if arg1&0x3 != 0 || arg2&0x14 != 0x10 {
+		return false, sm.execError(word, "reserved instruction")
+	}
+	if sm.pio.version < 1 {
+		return false, sm.execError(word, "`mov rxfifo` requires PIO version 1")
+	}
+	index := arg2 & 0x3
+	if arg2&0x8 == 0 {
+		index = sm.y & 0x3
+	}
+
+	join := sm.config.FifoJoin
+	if arg1&0x4 != 0 {
+		if join != compiler.FifoJoinTxGet && join != compiler.FifoJoinPutGet {
+			return false, sm.execError(word, "`mov osr, rxfifo` requires `.fifo txget` or `.fifo putget`")
+		}
+		sm.osr = sm.rxRegisters[index]
+		sm.osrCount = 0
+	} else {
+		if join != compiler.FifoJoinTxPut && join != compiler.FifoJoinPutGet {
+			return false, sm.execError(word, "`mov rxfifo, isr` requires `.fifo txput` or `.fifo putget`")
+		}
+		sm.rxRegisters[index] = sm.isr
+	}
+
+	return true, nil
+}
+
+func (sm *StateMachine) mov(word uint16, destination int, arg2 uint32) (bool, error) {
+	var data uint32
+	switch arg2 & 0x7 {
+	case 0:
+		data = sm.inPins()
+	case 1:
+		data = sm.x
+	case 2:
+		data = sm.y
+	case 3:
+		data = 0
+	case 5:
+		status, err := sm.status(word)
+		if err != nil {
+			return false, err
+		}
+		data = status
+	case 6:
+		data = sm.isr
+	case 7:
+		data = sm.osr
+	default:
+		return false, sm.execError(word, "reserved `mov` source")
+	}
+	switch arg2 >> 3 {
+	case 0:
+	case 1:
+		data = ^data
+	case 2:
+		data = bits.Reverse32(data)
+	default:
+		return false, sm.execError(word, "reserved `mov` operation")
+	}
+
+	switch destination {
+	case 0:
+		sm.writePins(false, sm.config.OutBase, sm.config.OutCount, data)
+	case 1:
+		sm.x = data
+	case 2:
+		sm.y = data
+	case 3:
+		if sm.pio.version < 1 {
+			return false, sm.execError(word, "`mov pindirs` requires PIO version 1")
+		}
+		sm.writePins(true, sm.config.OutBase, sm.config.OutCount, data)
+	case 4:
+		sm.execNext(uint16(data))
+	case 5:
+		sm.jump(data)
+	case 6:
+		sm.isr = data
+		sm.isrCount = 0
+	default:
+		sm.osr = data
+		sm.osrCount = 0
+	}
+
+	return true, nil
+}
+
+// status returns all ones if the condition of `.mov_status` holds, otherwise zero.
+func (sm *StateMachine) status(word uint16) (uint32, error) {
+	var holds bool
+	switch sm.config.MovStatus {
+	case compiler.MovStatusTxLessThan:
+		holds = sm.tx.level() < sm.config.MovStatusN
+	case compiler.MovStatusRxLessThan:
+		holds = sm.rx.level() < sm.config.MovStatusN
+	default:
+		flag, err := sm.irqFlag(word, uint32(sm.config.MovStatusN))
+		if err != nil {
+			return 0, err
+		}
+		holds = sm.pio.irq&flag != 0
+	}
+	if holds {
+		return 0xffffffff, nil
+	}
+
+	return 0, nil
+}
+
+func (sm *StateMachine) irq(word uint16, arg1 int, arg2 uint32) (bool, error) {
+	if arg1&0x4 != 0 {
+		return false, sm.execError(word, "reserved instruction")
+	}
+	flag, err := sm.irqFlag(word, arg2)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case arg1&0x2 != 0:
+		sm.irqClear |= flag
+	case arg1&0x1 != 0:
+		// `irq wait` sets the flag once, then stalls until it is cleared
+		if !sm.irqWaiting {
+			sm.irqSet |= flag
+			sm.irqWaiting = true
+			return false, nil
+		}
+		if sm.pio.irq&flag != 0 {
+			return false, nil
+		}
+		sm.irqWaiting = false
+	default:
+		sm.irqSet |= flag
+	}
+
+	return true, nil
+}
+
+// irqFlag returns the mask of the IRQ flag addressed by the index of `irq`, `wait irq` and `.mov_status`.
+func (sm *StateMachine) irqFlag(word uint16, index uint32) (uint8, error) {
+	flag := index & 0x7
+	switch index >> 3 & 0x3 {
+	case 0:
+	case 2:
+		flag = flag&0x4 | (flag+uint32(sm.index))&0x3
+	default:
+		if sm.pio.version < 1 {
+			return 0, sm.execError(word, "`prev` and `next` require PIO version 1")
+		}
+		return 0, sm.execError(word, "IRQ flags of other PIO blocks are not supported")
+	}
+
+	return 1 << flag, nil
+}
+
+func (sm *StateMachine) set(word uint16, destination int, value uint32) (bool, error) {
+	switch destination {
+	case 0:
+		sm.writePins(false, sm.config.SetBase, sm.config.SetCount, value)
+	case 1:
+		sm.x = value
+	case 2:
+		sm.y = value
+	case 4:
+		sm.writePins(true, sm.config.SetBase, sm.config.SetCount, value)
+	default:
+		return false, sm.execError(word, "reserved `set` destination")
+	}
+
+	return true, nil
+}
+
+// writePins writes the pins at the end of the cycle.
+func (sm *StateMachine) writePins(enables bool, base int, count int, value uint32) {
+	sm.writes = append(sm.writes, pinWrite{enables: enables, base: base, count: count, value: value})
+}
+
+// commit applies the writes of the cycle. The side-set takes priority over the instruction.
+func (sm *StateMachine) commit() {
+	gpio := &sm.pio.gpio
+	for _, write := range append(sm.writes, sm.sideSetWrites...) {
+		if write.enables {
+			gpio.writeEnables(write.base, write.count, write.value)
+		} else {
+			gpio.writeOutputs(write.base, write.count, write.value)
+		}
+	}
+	sm.pio.irq = sm.pio.irq&^sm.irqClear | sm.irqSet
+	sm.writes = sm.writes[:0]
+	sm.sideSetWrites = sm.sideSetWrites[:0]
+	sm.irqSet = 0
+	sm.irqClear = 0
+}
+
+// inPins returns the levels of the pins from the in base, limited to the count of `.in`.
+func (sm *StateMachine) inPins() uint32 {
+	return bits.RotateLeft32(sm.pio.gpio.Levels(), -pinIndex(sm.config.InBase)) & lowMask(sm.config.InCount)
+}
+
+func (sm *StateMachine) jump(target uint32) {
+	sm.pc = int(target & 0x1f)
+	sm.jumped = true
+}
+
+// execNext executes the instruction in the next cycle. The delay of the instruction requesting it is ignored.
+func (sm *StateMachine) execNext(word uint16) {
+	sm.exec = word
+	sm.execPending = true
+	sm.skipDelay = true
+}
+
+func (sm *StateMachine) execError(word uint16, message string) error {
+	return &ExecError{SM: sm.index, PC: sm.pc, Word: word, Message: message}
+}
+
+// sideSetPins returns the number of pins written by the side-set, which excludes the enable bit.
+func sideSetPins(config Config) int {
+	if config.SideSetOptional {
+		return config.SideSetCount - 1
+	}
+
+	return config.SideSetCount
+}
+
+// bitCount decodes the bit count of `in` and `out`, where 0 stands for 32.
+func bitCount(arg2 uint32) int {
+	if arg2 == 0 {
+		return 32
+	}
+
+	return int(arg2)
+}
+
+func saturate(count int) int {
+	if count > 32 {
+		return 32
+	}
+
+	return count
+}
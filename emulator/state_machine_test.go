@@ -0,0 +1,389 @@
+package emulator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+)
+
+// start compiles the source, loads its first program at 0 and enables the state machine 0 with the
+// default configuration of the program altered by configure.
+func start(t *testing.T, version int, source string, configure func(*Config)) (*PIO, *StateMachine) {
+	t.Helper()
+	file, e := compiler.Compile(source, &compiler.Options{})
+	if e != nil {
+		t.Fatalf("%s", e.ToString())
+	}
+	program := file.Programs()[0]
+	pio := New(version)
+	if err := pio.LoadProgram(program, 0); err != nil {
+		t.Fatal(err)
+	}
+	config := NewConfig(program, 0)
+	if configure != nil {
+		configure(&config)
+	}
+	sm := pio.StateMachine(0)
+	sm.Init(0, config)
+	sm.SetEnabled(true)
+
+	return pio, sm
+}
+
+func run(t *testing.T, pio *PIO, cycles int) {
+	t.Helper()
+	if err := pio.Run(cycles); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_StateMachine(t *testing.T) {
+	t.Run("Sets pins and pindirs from the set base.", func(t *testing.T) {
+		pio, _ := start(t, 0, `.program test
+.set 2
+set pindirs, 3
+set pins, 2
+`, func(config *Config) { config.SetBase = 4 })
+
+		run(t, pio, 1)
+		if got := pio.GPIO().OutputEnables(); got != 0x30 {
+			t.Errorf("enables: 0x%x", got)
+		}
+		run(t, pio, 1)
+		if got := pio.GPIO().Levels(); got != 0x20 {
+			t.Errorf("levels: 0x%x", got)
+		}
+	})
+
+	t.Run("Sets consecutive pindirs.", func(t *testing.T) {
+		pio, sm := start(t, 0, ".program test\nnop\n", nil)
+
+		sm.SetConsecutivePindirs(30, 3, true)
+		sm.SetConsecutivePindirs(31, 1, false)
+		if got := pio.GPIO().OutputEnables(); got != 0x40000001 {
+			t.Errorf("enables: 0x%x", got)
+		}
+	})
+
+	t.Run("Loops with `jmp x--`.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+set x, 3
+loop:
+jmp x--, loop
+set y, 7
+`, nil)
+
+		run(t, pio, 5)
+		if sm.PC() != 2 || sm.Y() != 0 {
+			t.Errorf("pc: %d, y: %d", sm.PC(), sm.Y())
+		}
+		run(t, pio, 1)
+		if sm.X() != 0xffffffff || sm.Y() != 7 {
+			t.Errorf("x: 0x%x, y: %d", sm.X(), sm.Y())
+		}
+	})
+
+	t.Run("Jumps on conditions.", func(t *testing.T) {
+		cases := []struct {
+			condition string
+			taken     bool
+		}{
+			{"!x", false},
+			{"!y", true},
+			{"x!=y", true},
+			{"pin", true},
+			{"!osre", false},
+		}
+
+		for _, tc := range cases {
+			pio, sm := start(t, 0, `.program test
+set x, 1
+jmp `+tc.condition+`, target
+set y, 1
+target:
+set y, 2
+`, func(config *Config) { config.JmpPin = 3 })
+			pio.GPIO().SetInput(3, true)
+
+			run(t, pio, 3)
+			if taken := sm.Y() == 2; taken != tc.taken {
+				t.Errorf("%s: y: %d", tc.condition, sm.Y())
+			}
+		}
+	})
+
+	t.Run("Delays after the instruction.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+set x, 1 [2]
+set x, 2
+`, nil)
+
+		run(t, pio, 3)
+		if sm.X() != 1 || sm.Delay() != 0 {
+			t.Errorf("x: %d, delay: %d", sm.X(), sm.Delay())
+		}
+		run(t, pio, 1)
+		if sm.X() != 2 {
+			t.Errorf("x: %d", sm.X())
+		}
+	})
+
+	t.Run("Wraps.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+set y, 5
+.wrap_target
+set x, 1
+set x, 2
+.wrap
+set y, 7
+`, nil)
+
+		run(t, pio, 4)
+		if sm.PC() != 2 || sm.X() != 1 || sm.Y() != 5 {
+			t.Errorf("pc: %d, x: %d, y: %d", sm.PC(), sm.X(), sm.Y())
+		}
+	})
+
+	t.Run("Applies the side-set while stalled.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.side_set 1 opt
+wait 1 gpio 0 side 1
+nop
+nop side 0
+`, func(config *Config) { config.SideSetBase = 2 })
+
+		run(t, pio, 2)
+		if !sm.Stalled() || sm.PC() != 0 || pio.GPIO().Outputs() != 0x4 {
+			t.Errorf("stalled: %t, pc: %d, outputs: 0x%x", sm.Stalled(), sm.PC(), pio.GPIO().Outputs())
+		}
+		pio.GPIO().SetInput(0, true)
+		run(t, pio, 2)
+		if sm.Stalled() || pio.GPIO().Outputs() != 0x4 {
+			t.Errorf("stalled: %t, outputs: 0x%x", sm.Stalled(), pio.GPIO().Outputs())
+		}
+		run(t, pio, 1)
+		if pio.GPIO().Outputs() != 0 {
+			t.Errorf("outputs: 0x%x", pio.GPIO().Outputs())
+		}
+	})
+
+	t.Run("Waits for a pin from the in base.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+wait 0 pin 2
+set x, 1
+`, func(config *Config) { config.InBase = 3 })
+		pio.GPIO().SetInput(5, true)
+
+		run(t, pio, 3)
+		if sm.X() != 0 {
+			t.Errorf("x: %d", sm.X())
+		}
+		pio.GPIO().SetInput(5, false)
+		run(t, pio, 2)
+		if sm.X() != 1 {
+			t.Errorf("x: %d", sm.X())
+		}
+	})
+
+	t.Run("Shifts out with autopull.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.out 8 right auto
+out pins, 8
+`, nil)
+
+		run(t, pio, 1)
+		if !sm.Stalled() {
+			t.Errorf("Doesn't stall on the empty TX FIFO")
+		}
+		sm.Put(0x04030201)
+		sm.Put(0x05)
+		for _, expected := range []uint32{1, 2, 3, 4, 5} {
+			run(t, pio, 1)
+			if got := pio.GPIO().Outputs(); got != expected {
+				t.Errorf("outputs: 0x%x != 0x%x", got, expected)
+			}
+		}
+		if sm.TxLevel() != 0 {
+			t.Errorf("TX level: %d", sm.TxLevel())
+		}
+	})
+
+	t.Run("Shifts in with autopush.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.in 32 left auto 8
+in pins, 4
+`, nil)
+		for _, pin := range []int{1, 3} {
+			pio.GPIO().SetInput(pin, true)
+		}
+
+		run(t, pio, 10)
+		for i := 0; i < 4; i++ {
+			if word, ok := sm.Get(); !ok || word != 0xaa {
+				t.Errorf("%d: 0x%x, %t", i, word, ok)
+			}
+		}
+		if !sm.Stalled() || sm.ISRCount() != 4 {
+			t.Errorf("stalled: %t, ISR count: %d", sm.Stalled(), sm.ISRCount())
+		}
+	})
+
+	t.Run("Pulls and pushes.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+pull
+mov isr, !osr
+push
+`, nil)
+
+		run(t, pio, 2)
+		if !sm.Stalled() || sm.Completed() || sm.PC() != 0 {
+			t.Errorf("stalled: %t, completed: %t, pc: %d", sm.Stalled(), sm.Completed(), sm.PC())
+		}
+		sm.Put(5)
+		run(t, pio, 1)
+		if sm.Stalled() || !sm.Completed() || sm.PC() != 1 {
+			t.Errorf("stalled: %t, completed: %t, pc: %d", sm.Stalled(), sm.Completed(), sm.PC())
+		}
+		run(t, pio, 2)
+		if word, ok := sm.Get(); !ok || word != ^uint32(5) {
+			t.Errorf("0x%x, %t", word, ok)
+		}
+	})
+
+	t.Run("Pulls x when not blocking.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+set x, 9
+pull noblock
+mov y, ::osr
+`, nil)
+
+		run(t, pio, 3)
+		if sm.Y() != 0x90000000 {
+			t.Errorf("y: 0x%x", sm.Y())
+		}
+	})
+
+	t.Run("Skips `push iffull` and `pull ifempty`.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.in 32 right 8
+.out 32 right 8
+in x, 4
+push iffull
+pull ifempty
+in x, 4
+push iffull
+`, nil)
+		sm.Put(1)
+
+		run(t, pio, 5)
+		if sm.RxLevel() != 1 || sm.TxLevel() != 0 {
+			t.Errorf("RX level: %d, TX level: %d", sm.RxLevel(), sm.TxLevel())
+		}
+	})
+
+	t.Run("Executes from the OSR and Exec.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+pull
+out exec, 16 [3]
+set y, 1
+`, nil)
+		// set x, 7
+		sm.Put(0xe027)
+
+		run(t, pio, 3)
+		if sm.X() != 7 || sm.PC() != 2 || sm.Y() != 0 {
+			t.Errorf("pc: %d, x: %d, y: %d", sm.PC(), sm.X(), sm.Y())
+		}
+		run(t, pio, 1)
+		if sm.Y() != 1 {
+			t.Errorf("y: %d", sm.Y())
+		}
+		// jmp 0
+		if err := sm.Exec(0x0000); err != nil || sm.PC() != 0 {
+			t.Errorf("pc: %d, %v", sm.PC(), err)
+		}
+	})
+
+	t.Run("Moves the status.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.mov_status txfifo < 2
+mov x, status
+mov y, status
+`, nil)
+
+		sm.Put(1)
+		run(t, pio, 1)
+		sm.Put(2)
+		run(t, pio, 1)
+		if sm.X() != 0xffffffff || sm.Y() != 0 {
+			t.Errorf("x: 0x%x, y: 0x%x", sm.X(), sm.Y())
+		}
+	})
+
+	t.Run("Joins the FIFOs.", func(t *testing.T) {
+		_, sm := start(t, 0, `.program test
+.fifo tx
+nop
+`, nil)
+
+		for i := 0; i < 8; i++ {
+			if !sm.Put(uint32(i)) {
+				t.Errorf("%d: TX FIFO full", i)
+			}
+		}
+		if sm.Put(8) {
+			t.Errorf("TX FIFO not full")
+		}
+	})
+
+	t.Run("Moves through the RX FIFO registers.", func(t *testing.T) {
+		pio, sm := start(t, 1, `.pio_version 1
+.program test
+.fifo putget
+set x, 5
+mov isr, x
+mov rxfifo[2], isr
+set y, 3
+mov osr, rxfifo[y]
+`, nil)
+		sm.SetRxFifoRegister(3, 11)
+
+		run(t, pio, 5)
+		if sm.RxFifoRegister(2) != 5 || sm.OSR() != 11 {
+			t.Errorf("register: %d, OSR: %d", sm.RxFifoRegister(2), sm.OSR())
+		}
+	})
+
+	t.Run("Divides the clock.", func(t *testing.T) {
+		pio, sm := start(t, 0, `.program test
+.clock_div 1.5
+set x, 1
+set x, 2
+set x, 3
+`, nil)
+
+		run(t, pio, 2)
+		if sm.X() != 1 {
+			t.Errorf("x: %d", sm.X())
+		}
+		run(t, pio, 1)
+		if sm.X() != 2 {
+			t.Errorf("x: %d", sm.X())
+		}
+	})
+
+	t.Run("Error if the instruction is not supported.", func(t *testing.T) {
+		pio, _ := start(t, 1, `.pio_version 1
+.program test
+nop
+irq next 0
+`, nil)
+
+		err := pio.Run(2)
+		var execError *ExecError
+		if !errors.As(err, &execError) || execError.PC != 1 || execError.Message != "IRQ flags of other PIO blocks are not supported" {
+			t.Errorf("%v", err)
+		}
+	})
+}
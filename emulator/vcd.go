@@ -0,0 +1,229 @@
+package emulator
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Signals selects the signals of the state machines recorded by a Tracer.
+type Signals int
+
+const (
+	SignalPC Signals = 1 << iota
+	SignalFifoLevels
+	SignalSideSet
+	SignalScratch
+	SignalStalled
+)
+
+// TraceConfig selects what a Tracer records.
+type TraceConfig struct {
+	// Pins are the GPIOs recorded
+	Pins []int
+	// StateMachines are the state machines whose Signals are recorded
+	StateMachines []int
+	// Signals of the state machines, the PC, the FIFO levels and the side-set pins if zero
+	Signals Signals
+	// Clock is the system clock in Hz, 125 MHz if zero. The state machines step every ClockDiv cycles of it.
+	Clock float64
+}
+
+// Tracer steps a PIO block and records the selected signals at every cycle of the system clock,
+// to be written as a VCD file viewable in GTKWave.
+type Tracer struct {
+	pio     *PIO
+	clock   float64
+	vars    []*traceVar
+	changes []traceChange
+}
+
+type traceVar struct {
+	id    string
+	scope string
+	name  string
+	width int
+	value func() uint32
+	// Values at the creation of the Tracer and at the last step
+	initial uint32
+	last    uint32
+}
+
+type traceChange struct {
+	cycle uint64
+	v     *traceVar
+	value uint32
+}
+
+// NewTracer records the current values of the signals of the PIO block.
+func NewTracer(pio *PIO, config TraceConfig) *Tracer {
+	t := &Tracer{pio: pio, clock: config.Clock}
+	if t.clock == 0 {
+		t.clock = 125e6
+	}
+	signals := config.Signals
+	if signals == 0 {
+		signals = SignalPC | SignalFifoLevels | SignalSideSet
+	}
+
+	gpio := pio.GPIO()
+	for _, pin := range config.Pins {
+		pin := pinIndex(pin)
+		t.addVar("gpio", fmt.Sprintf("gpio%d", pin), 1, func() uint32 { return gpio.Levels() >> pin & 1 })
+	}
+	for _, index := range config.StateMachines {
+		sm := pio.StateMachine(index)
+		scope := fmt.Sprintf("sm%d", index)
+		if signals&SignalPC != 0 {
+			t.addVar(scope, "pc", 5, func() uint32 { return uint32(sm.PC()) })
+		}
+		if signals&SignalFifoLevels != 0 {
+			t.addVar(scope, "tx_level", 4, func() uint32 { return uint32(sm.TxLevel()) })
+			t.addVar(scope, "rx_level", 4, func() uint32 { return uint32(sm.RxLevel()) })
+		}
+		if pins := sideSetPins(sm.Config()); signals&SignalSideSet != 0 && pins > 0 {
+			t.addVar(scope, "side_set", pins, func() uint32 {
+				config := sm.Config()
+				levels := gpio.Levels()
+				if config.SideSetPindirs {
+					levels = gpio.OutputEnables()
+				}
+				return bits.RotateLeft32(levels, -pinIndex(config.SideSetBase)) & lowMask(pins)
+			})
+		}
+		if signals&SignalScratch != 0 {
+			t.addVar(scope, "x", 32, sm.X)
+			t.addVar(scope, "y", 32, sm.Y)
+		}
+		if signals&SignalStalled != 0 {
+			t.addVar(scope, "stalled", 1, func() uint32 {
+				if sm.Stalled() {
+					return 1
+				}
+				return 0
+			})
+		}
+	}
+
+	for _, v := range t.vars {
+		v.initial = v.value()
+		v.last = v.initial
+	}
+
+	return t
+}
+
+func (t *Tracer) addVar(scope string, name string, width int, value func() uint32) {
+	t.vars = append(t.vars, &traceVar{id: vcdIdentifier(len(t.vars)), scope: scope, name: name, width: width, value: value})
+}
+
+// Step steps the PIO block by one system clock cycle and records the signals which changed.
+func (t *Tracer) Step() error {
+	err := t.pio.Step()
+	for _, v := range t.vars {
+		if value := v.value(); value != v.last {
+			t.changes = append(t.changes, traceChange{cycle: t.pio.Cycles(), v: v, value: value})
+			v.last = value
+		}
+	}
+
+	return err
+}
+
+// Run steps the PIO block by the number of system clock cycles or until an error.
+func (t *Tracer) Run(cycles int) error {
+	for i := 0; i < cycles; i++ {
+		if err := t.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteVCD writes the recorded signals as a Value Change Dump. The timescale and the timestamps come from
+// the system clock, not the one of a state machine, so a clock divider spaces the changes of its signals.
+func (t *Tracer) WriteVCD(w io.Writer) error {
+	var b strings.Builder
+	timescale, period := vcdTimescale(t.clock)
+
+	b.WriteString("$version pioasm-compiler emulator $end\n")
+	b.WriteString(fmt.Sprintf("$timescale %s $end\n", timescale))
+	b.WriteString("$scope module pio $end\n")
+	scope := ""
+	for _, v := range t.vars {
+		if v.scope != scope {
+			if scope != "" {
+				b.WriteString("$upscope $end\n")
+			}
+			scope = v.scope
+			b.WriteString(fmt.Sprintf("$scope module %s $end\n", scope))
+		}
+		kind := "wire"
+		if v.width > 1 && v.name != "side_set" {
+			kind = "reg"
+		}
+		b.WriteString(fmt.Sprintf("$var %s %d %s %s $end\n", kind, v.width, v.id, v.name))
+	}
+	if scope != "" {
+		b.WriteString("$upscope $end\n")
+	}
+	b.WriteString("$upscope $end\n")
+	b.WriteString("$enddefinitions $end\n")
+
+	b.WriteString("#0\n$dumpvars\n")
+	for _, v := range t.vars {
+		b.WriteString(vcdValue(v, v.initial))
+	}
+	b.WriteString("$end\n")
+
+	cycle := uint64(0)
+	for _, change := range t.changes {
+		if change.cycle != cycle {
+			cycle = change.cycle
+			b.WriteString(fmt.Sprintf("#%d\n", cycle*period))
+		}
+		b.WriteString(vcdValue(change.v, change.value))
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// vcdTimescale returns the coarsest VCD time unit in which the period of the clock is whole, and the period in it.
+func vcdTimescale(clock float64) (string, uint64) {
+	femtoseconds := uint64(math.Round(1e15 / clock))
+	units := []string{"s", "ms", "us", "ns", "ps", "fs"}
+	for i, unit := range units {
+		for _, magnitude := range []uint64{100, 10, 1} {
+			scale := magnitude * uint64(math.Pow10(3*(len(units)-1-i)))
+			if femtoseconds%scale == 0 {
+				return fmt.Sprintf("%d %s", magnitude, unit), femtoseconds / scale
+			}
+		}
+	}
+
+	return "1 fs", femtoseconds
+}
+
+func vcdValue(v *traceVar, value uint32) string {
+	if v.width == 1 {
+		return fmt.Sprintf("%d%s\n", value, v.id)
+	}
+
+	return fmt.Sprintf("b%b %s\n", value, v.id)
+}
+
+// vcdIdentifier returns the short identifier of the variable n made of the printable characters.
+func vcdIdentifier(n int) string {
+	const first, count = '!', '~' - '!' + 1
+	id := string(rune(first + n%count))
+	for n /= count; n > 0; n /= count {
+		id += string(rune(first + n%count))
+	}
+
+	return id
+}
@@ -0,0 +1,136 @@
+package emulator
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Tracer(t *testing.T) {
+	t.Run("Writes the changes of the signals.", func(t *testing.T) {
+		pio, _ := start(t, 0, `.program test
+.side_set 1 opt
+.set 1
+	set pindirs, 1
+	nop side 1 [1]
+	nop side 0
+`, nil)
+		tracer := NewTracer(pio, TraceConfig{Pins: []int{0}, StateMachines: []int{0}})
+
+		if err := tracer.Run(4); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := tracer.WriteVCD(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		if b.String() != `$version pioasm-compiler emulator $end
+$timescale 1 ns $end
+$scope module pio $end
+$scope module gpio $end
+$var wire 1 ! gpio0 $end
+$upscope $end
+$scope module sm0 $end
+$var reg 5 " pc $end
+$var reg 4 # tx_level $end
+$var reg 4 $ rx_level $end
+$var wire 1 % side_set $end
+$upscope $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+0!
+b0 "
+b0 #
+b0 $
+0%
+$end
+#8
+b1 "
+#16
+1!
+b10 "
+1%
+#32
+0!
+b0 "
+0%
+` {
+			t.Log(b.String())
+			t.Errorf("VCD is different")
+		}
+	})
+
+	t.Run("Selects the signals.", func(t *testing.T) {
+		pio, _ := start(t, 0, `.program test
+	set x, 3
+	pull
+`, nil)
+		tracer := NewTracer(pio, TraceConfig{StateMachines: []int{0}, Signals: SignalScratch | SignalStalled})
+
+		if err := tracer.Run(2); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		_ = tracer.WriteVCD(&b)
+
+		if !bytes.Contains(b.Bytes(), []byte("$var reg 32 ! x $end\n$var reg 32 \" y $end\n$var wire 1 # stalled $end\n")) ||
+			!bytes.HasSuffix(b.Bytes(), []byte("#8\nb11 !\n#16\n1#\n")) {
+			t.Log(b.String())
+			t.Errorf("VCD is different")
+		}
+	})
+
+	t.Run("Times the changes in cycles of the system clock with a clock divider.", func(t *testing.T) {
+		pio, _ := start(t, 0, `.program test
+	set x, 1
+	set x, 2
+	set x, 3
+`, func(config *Config) {
+			config.ClockDiv = 2.5
+		})
+		tracer := NewTracer(pio, TraceConfig{StateMachines: []int{0}, Signals: SignalScratch})
+
+		if err := tracer.Run(8); err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		_ = tracer.WriteVCD(&b)
+
+		if !bytes.Contains(b.Bytes(), []byte("$timescale 1 ns $end\n")) ||
+			!bytes.HasSuffix(b.Bytes(), []byte("#24\nb1 !\n#40\nb10 !\n#64\nb11 !\n")) {
+			t.Log(b.String())
+			t.Errorf("VCD is different")
+		}
+	})
+
+	t.Run("Derives the timescale from the clock.", func(t *testing.T) {
+		cases := []struct {
+			clock     float64
+			timescale string
+			period    uint64
+		}{
+			{125e6, "1 ns", 8},
+			{50e6, "10 ns", 2},
+			{1e3, "1 ms", 1},
+			{133e6, "1 fs", 7518797},
+		}
+
+		for _, tc := range cases {
+			if timescale, period := vcdTimescale(tc.clock); timescale != tc.timescale || period != tc.period {
+				t.Errorf("%v: %s, %d", tc.clock, timescale, period)
+			}
+		}
+	})
+
+	t.Run("Makes identifiers of printable characters.", func(t *testing.T) {
+		cases := map[int]string{0: "!", 93: "~", 94: "!\""}
+
+		for n, id := range cases {
+			if got := vcdIdentifier(n); got != id {
+				t.Errorf("%d: %s != %s", n, got, id)
+			}
+		}
+	})
+}
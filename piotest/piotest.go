@@ -0,0 +1,230 @@
+// Package piotest runs PIO programs on the emulator in Go tests: it feeds the TX FIFO, drives input pins
+// from a script and checks the RX FIFO and the pin waveforms.
+package piotest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bozydar/pioasm-compiler/compiler"
+	"github.com/bozydar/pioasm-compiler/emulator"
+)
+
+// Setup configures the state machine started by Start.
+type Setup struct {
+	// Program is the name of the program to run, the first of the source if empty
+	Program string
+	// PioVersion of the emulated PIO block and of the programs without `.pio_version`: 0 for RP2040, 1 for RP2350
+	PioVersion int
+	// Configure alters the default configuration of the program, e.g. to set the pins, if not nil
+	Configure func(*emulator.Config)
+	// Timeout is the number of cycles Put and ExpectRx wait at most, 10000 if zero
+	Timeout int
+}
+
+// Edge is the level of a pin from a cycle on. The state machine samples an input driven at a cycle in the next one.
+type Edge struct {
+	At    uint64
+	Level bool
+}
+
+// Machine is a state machine running a program in a test. It records the levels of all pins.
+type Machine struct {
+	t       testing.TB
+	file    *compiler.AstFile
+	pio     *emulator.PIO
+	sm      *emulator.StateMachine
+	timeout int
+	// Number of state machines started and the offset of the next program
+	started int
+	offset  int
+	// Edges of the pins driven from the outside, sorted by cycle
+	stimulus map[int][]Edge
+	// Levels of the pins since the start
+	levels []levelChange
+}
+
+type levelChange struct {
+	at     uint64
+	levels uint32
+}
+
+// Start compiles the source, loads the program at offset 0 and enables the state machine 0.
+func Start(t testing.TB, source string, setup Setup) *Machine {
+	t.Helper()
+	file, e := compiler.Compile(source, &compiler.Options{PioVersion: setup.PioVersion})
+	if e != nil {
+		t.Fatalf("Compile error: %s", e.ToString())
+	}
+
+	m := &Machine{t: t, file: file, pio: emulator.New(setup.PioVersion), timeout: setup.Timeout, stimulus: make(map[int][]Edge)}
+	if m.timeout == 0 {
+		m.timeout = 10000
+	}
+	m.sm = m.StartProgram(setup.Program, setup.Configure)
+	m.levels = []levelChange{{at: 0, levels: m.pio.GPIO().Levels()}}
+
+	return m
+}
+
+// StartProgram loads the program of the source after the loaded ones and enables the next state machine,
+// which runs in lockstep with the others. The first program of the source is loaded if the name is empty.
+func (m *Machine) StartProgram(name string, configure func(*emulator.Config)) *emulator.StateMachine {
+	m.t.Helper()
+	var program *compiler.AstProgram
+	if name == "" && len(m.file.Programs()) > 0 {
+		program = m.file.Programs()[0]
+	} else {
+		program = m.file.Program(name)
+	}
+	if program == nil {
+		m.t.Fatalf("Program `%s` not found", name)
+	}
+	if m.started == 4 {
+		m.t.Fatalf("No state machine left for `%s`", program.Name())
+	}
+
+	if err := m.pio.LoadProgram(program, m.offset); err != nil {
+		m.t.Fatal(err)
+	}
+	config := emulator.NewConfig(program, m.offset)
+	if configure != nil {
+		configure(&config)
+	}
+	sm := m.pio.StateMachine(m.started)
+	sm.Init(m.offset, config)
+	sm.SetEnabled(true)
+	m.offset += len(program.Instructions())
+	m.started++
+
+	return sm
+}
+
+func (m *Machine) PIO() *emulator.PIO {
+	return m.pio
+}
+
+// StateMachine returns the state machine started by Start.
+func (m *Machine) StateMachine() *emulator.StateMachine {
+	return m.sm
+}
+
+// Cycle returns the number of cycles run.
+func (m *Machine) Cycle() uint64 {
+	return m.pio.Cycles()
+}
+
+// Drive schedules the levels the pin is driven to from the outside. An edge at the current cycle or
+// before is applied right away.
+func (m *Machine) Drive(pin int, edges ...Edge) {
+	m.stimulus[pin] = append(m.stimulus[pin], edges...)
+	sort.SliceStable(m.stimulus[pin], func(i, j int) bool { return m.stimulus[pin][i].At < m.stimulus[pin][j].At })
+	m.applyStimulus()
+}
+
+// Put writes the words to the TX FIFO, running the state machine while it is full.
+func (m *Machine) Put(words ...uint32) {
+	m.t.Helper()
+	for _, word := range words {
+		for waited := 0; !m.sm.Put(word); waited++ {
+			if waited == m.timeout {
+				m.t.Fatalf("TX FIFO full for %d cycles", m.timeout)
+			}
+			m.step()
+		}
+	}
+}
+
+// Run runs the state machine for the number of cycles.
+func (m *Machine) Run(cycles int) {
+	m.t.Helper()
+	for i := 0; i < cycles; i++ {
+		m.step()
+	}
+}
+
+// ExpectRx reads the words from the RX FIFO, waiting for each at most within cycles.
+func (m *Machine) ExpectRx(within int, words ...uint32) {
+	m.t.Helper()
+	for i, expected := range words {
+		waited := 0
+		for ; m.sm.RxLevel() == 0 && waited < within; waited++ {
+			m.step()
+		}
+		word, ok := m.sm.Get()
+		if !ok {
+			m.t.Errorf("RX word %d: nothing within %d cycles, expected 0x%x", i, within, expected)
+			return
+		}
+		if word != expected {
+			m.t.Errorf("RX word %d: 0x%x != 0x%x at cycle %d", i, word, expected, m.Cycle())
+		}
+	}
+}
+
+// Waveform returns the levels of the pin since the start, beginning with the level at cycle 0.
+func (m *Machine) Waveform(pin int) []Edge {
+	var result []Edge
+	for _, change := range m.levels {
+		level := change.levels>>(pin&0x1f)&1 != 0
+		if len(result) == 0 || result[len(result)-1].Level != level {
+			result = append(result, Edge{At: change.at, Level: level})
+		}
+	}
+
+	return result
+}
+
+// ExpectWaveform checks the levels of the pin since the start. Each edge may be off by the tolerance in cycles.
+func (m *Machine) ExpectWaveform(pin int, tolerance uint64, edges ...Edge) {
+	m.t.Helper()
+	got := m.Waveform(pin)
+	for i, expected := range edges {
+		if i >= len(got) {
+			m.t.Errorf("Pin %d: missing edge %d to %t at cycle %d", pin, i, expected.Level, expected.At)
+			return
+		}
+		edge := got[i]
+		if edge.Level != expected.Level || edge.At+tolerance < expected.At || edge.At > expected.At+tolerance {
+			m.t.Errorf("Pin %d: edge %d to %t at cycle %d, expected to %t at cycle %d±%d",
+				pin, i, edge.Level, edge.At, expected.Level, expected.At, tolerance)
+			return
+		}
+	}
+	if len(got) > len(edges) {
+		edge := got[len(edges)]
+		m.t.Errorf("Pin %d: unexpected edge %d to %t at cycle %d", pin, len(edges), edge.Level, edge.At)
+	}
+}
+
+// step applies the stimulus due and runs one cycle.
+func (m *Machine) step() {
+	m.t.Helper()
+	if err := m.pio.Step(); err != nil {
+		m.t.Fatal(err)
+	}
+	m.applyStimulus()
+}
+
+// applyStimulus drives the pins to the levels due at the current cycle and records the levels.
+func (m *Machine) applyStimulus() {
+	gpio := m.pio.GPIO()
+	for pin, edges := range m.stimulus {
+		for len(edges) > 0 && edges[0].At <= m.Cycle() {
+			gpio.SetInput(pin, edges[0].Level)
+			edges = edges[1:]
+		}
+		m.stimulus[pin] = edges
+	}
+
+	levels := gpio.Levels()
+	last := &m.levels[len(m.levels)-1]
+	if last.levels == levels {
+		return
+	}
+	if last.at == m.Cycle() {
+		last.levels = levels
+	} else {
+		m.levels = append(m.levels, levelChange{at: m.Cycle(), levels: levels})
+	}
+}
@@ -0,0 +1,169 @@
+package piotest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bozydar/pioasm-compiler/emulator"
+)
+
+const uartTx = `
+.program uart_tx
+.side_set 1 opt
+	pull       side 1 [7]
+	set x, 7   side 0 [7]
+bitloop:
+	out pins, 1
+	jmp x-- bitloop [6]
+`
+
+// startUartTx starts the UART transmitter of pico-examples on the pin 0.
+func startUartTx(t testing.TB) *Machine {
+	m := Start(t, uartTx, Setup{Configure: func(config *emulator.Config) {
+		config.OutCount = 1
+	}})
+	m.StateMachine().SetConsecutivePindirs(0, 1, true)
+
+	return m
+}
+
+// uartA is the waveform of `A` sent by uart_tx.
+var uartA = []Edge{{0, false}, {1, true}, {9, false}, {17, true}, {25, false}, {65, true}, {73, false}, {81, true}}
+
+// recorder collects the failures of the checks under test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func Test_Machine(t *testing.T) {
+	t.Run("Checks the waveform of a pin.", func(t *testing.T) {
+		m := startUartTx(t)
+
+		m.Put('A')
+		m.Run(100)
+
+		m.ExpectWaveform(0, 0, uartA...)
+		if !m.StateMachine().Stalled() {
+			t.Errorf("Doesn't wait for the next character")
+		}
+	})
+
+	t.Run("Tolerates edges off by cycles.", func(t *testing.T) {
+		r := &recorder{TB: t}
+		m := startUartTx(r)
+		shifted := append([]Edge(nil), uartA...)
+		shifted[3].At += 2
+
+		m.Put('A')
+		m.Run(100)
+
+		m.ExpectWaveform(0, 2, shifted...)
+		if len(r.errors) != 0 {
+			t.Errorf("%v", r.errors)
+		}
+		m.ExpectWaveform(0, 1, shifted...)
+		if len(r.errors) != 1 || r.errors[0] != "Pin 0: edge 3 to true at cycle 17, expected to true at cycle 19±1" {
+			t.Errorf("%v", r.errors)
+		}
+	})
+
+	t.Run("Reports missing and unexpected edges.", func(t *testing.T) {
+		r := &recorder{TB: t}
+		m := startUartTx(r)
+
+		m.Put('A')
+		m.Run(100)
+
+		m.ExpectWaveform(0, 0, append(uartA, Edge{90, false})...)
+		m.ExpectWaveform(0, 0, uartA[:7]...)
+		if len(r.errors) != 2 ||
+			r.errors[0] != "Pin 0: missing edge 8 to false at cycle 90" ||
+			r.errors[1] != "Pin 0: unexpected edge 7 to true at cycle 81" {
+			t.Errorf("%v", r.errors)
+		}
+	})
+
+	t.Run("Runs while the TX FIFO is full.", func(t *testing.T) {
+		m := startUartTx(t)
+
+		m.Put('H', 'e', 'l', 'l', 'o')
+
+		if m.Cycle() != 1 || m.StateMachine().TxLevel() != 4 {
+			t.Errorf("cycle: %d, TX level: %d", m.Cycle(), m.StateMachine().TxLevel())
+		}
+	})
+
+	t.Run("Drives pins and reads the RX FIFO.", func(t *testing.T) {
+		m := Start(t, `
+.program sample
+.in 32 left auto 4
+	in pins, 1 [1]
+`, Setup{})
+
+		m.Drive(0, Edge{0, true}, Edge{2, false}, Edge{4, true})
+
+		m.ExpectRx(10, 0xb, 0xf)
+		if m.Cycle() != 15 {
+			t.Errorf("cycle: %d", m.Cycle())
+		}
+	})
+
+	t.Run("Reports missing RX words.", func(t *testing.T) {
+		r := &recorder{TB: t}
+		m := Start(r, ".program idle\n\tnop\n", Setup{})
+
+		m.ExpectRx(10, 1)
+		if len(r.errors) != 1 || r.errors[0] != "RX word 0: nothing within 10 cycles, expected 0x1" || m.Cycle() != 10 {
+			t.Errorf("%v", r.errors)
+		}
+	})
+
+	t.Run("Compiles the program for the PIO version.", func(t *testing.T) {
+		m := Start(t, `
+.program store
+.fifo putget
+	set x, 5
+	mov isr, x
+	mov rxfifo[1], isr
+`, Setup{PioVersion: 1})
+
+		m.Run(3)
+		if m.StateMachine().RxFifoRegister(1) != 5 {
+			t.Errorf("RX FIFO register 1: %d", m.StateMachine().RxFifoRegister(1))
+		}
+	})
+
+	t.Run("Selects the program.", func(t *testing.T) {
+		m := Start(t, ".program first\n\tset x, 1\n.program second\n\tset x, 2\n", Setup{Program: "second"})
+
+		m.Run(1)
+		if m.StateMachine().X() != 2 {
+			t.Errorf("x: %d", m.StateMachine().X())
+		}
+	})
+
+	t.Run("Runs programs on several state machines.", func(t *testing.T) {
+		m := Start(t, `
+.program consumer
+	wait 1 irq 0
+	in x, 4
+	push
+.program producer
+	set x, 5
+	irq set 0
+`, Setup{})
+		producer := m.StartProgram("producer", nil)
+
+		m.ExpectRx(5, 0)
+		if producer.X() != 5 || m.StateMachine().X() != 0 {
+			t.Errorf("producer x: %d, consumer x: %d", producer.X(), m.StateMachine().X())
+		}
+	})
+}